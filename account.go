@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// AccountSender publishes to a single account, see ForAccount
+type AccountSender struct {
+	client *Client
+	accid  string
+	route  atomic.Value // *accountRoute
+}
+
+// accountRoute is the shard of the account for a given set of shards
+type accountRoute struct {
+	nodes *nodes
+	shard int
+}
+
+// ForAccount returns a sender bound to accid, for loops publishing to the
+// same account. The account's shard is computed once instead of on every
+// send, and again only after SetMaxNodes. The connection isn't held by the
+// sender: it is the shard's, so a reconnected or recycled connection is
+// picked up by the next send. Sends otherwise behave like Send
+func (me *Client) ForAccount(accid string) *AccountSender {
+	return &AccountSender{client: me, accid: accid}
+}
+
+// AccountID returns the account the sender publishes to
+func (me *AccountSender) AccountID() string { return me.accid }
+
+// Send publishes payload to topics of the sender's account
+func (me *AccountSender) Send(topics []string, payload []byte) error {
+	return me.SendContext(context.Background(), topics, payload)
+}
+
+// SendContext is like Send but the publish uses ctx
+func (me *AccountSender) SendContext(ctx context.Context, topics []string, payload []byte) error {
+	if me.client.topicRouting {
+		// the shard depends on the topics, nothing to cache
+		_, err := me.client.publish(ctx, me.accid, topics, payload)
+		return err
+	}
+
+	no, err := me.shard()
+	if err != nil {
+		return err
+	}
+
+	ctx = context.WithValue(ctx, sendOptionsKey{}, &sendOptions{routed: true, shard: no})
+	_, err = me.client.publish(ctx, me.accid, topics, payload)
+	return err
+}
+
+// shard returns the account's shard, computing it again when the shards
+// changed since it was cached
+func (me *AccountSender) shard() (int, error) {
+	current := me.client.nodes.Load().(*nodes)
+	if route, _ := me.route.Load().(*accountRoute); route != nil && route.nodes == current {
+		return route.shard, nil
+	}
+
+	no, err := me.client.shardOf(me.accid)
+	if err != nil {
+		return 0, err
+	}
+	me.route.Store(&accountRoute{nodes: current, shard: no})
+	return no, nil
+}
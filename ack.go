@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// AckModeHeader is the metadata key carrying the acknowledgment level of a
+// publish, see WithAckMode
+const AckModeHeader = "x-ack-mode"
+
+// AckMode is how far a message must have gone before the realtime service
+// replies to its publish
+type AckMode int
+
+const (
+	AckDefault AckMode = iota // no header is sent, the server decides
+	AckNone                   // reply right away, fire and forget
+	AckLeader                 // reply once the shard's leader stored it
+	AckAll                    // reply once every replica stored it
+)
+
+func (m AckMode) String() string {
+	switch m {
+	case AckNone:
+		return "none"
+	case AckLeader:
+		return "leader"
+	case AckAll:
+		return "all"
+	}
+	return ""
+}
+
+// WithAck overrides the client wide WithAckMode for this call
+func WithAck(mode AckMode) SendOption {
+	return func(o *sendOptions) { o.ack = mode }
+}
+
+// withAckMode adds the acknowledgment level of the publish to ctx
+func (me *Client) withAckMode(ctx context.Context) context.Context {
+	mode := me.ackMode
+	if o := sendOptionsOf(ctx); o.ack != AckDefault {
+		mode = o.ack
+	}
+
+	if mode == AckDefault {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, AckModeHeader, mode.String())
+}
@@ -0,0 +1,208 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	defaultAsyncQueueSize = 1024
+	asyncWorkers          = 8
+
+	// errors kept for Flush between two calls, the rest are only counted
+	maxFlushErrors = 100
+)
+
+// ErrQueueFull is returned by SendAsync when the async queue has no room
+// left for another message
+var ErrQueueFull = newLocalReject("realtime client: async queue is full")
+
+type asyncJob struct {
+	accid   string
+	topics  []string
+	payload []byte
+	cb      func(error)
+}
+
+// SendAsync queues a publish and returns without waiting for the RPC. cb
+// (if not nil) is called from a worker goroutine with the result once the
+// publish completes. It returns ErrQueueFull instead of blocking when the
+// queue is full, see WithAsyncQueueSize. With WithOrderedDelivery, the
+// messages of an account are published in the order SendAsync was called
+func (me *Client) SendAsync(accid string, topics []string, payload []byte, cb func(error)) error {
+	if err := me.checkAccount(accid); err != nil {
+		return err
+	}
+
+	if all := me.expandTopics(topics); len(all) > 0 {
+		// reject invalid messages (e.g. a nil payload) now rather than through cb
+		if err := me.checkMessage(all, payload); err != nil {
+			return err
+		}
+	} else if err := me.emptyTopics(accid); err != nil {
+		return err
+	}
+
+	me.asyncOnce.Do(me.startAsyncWorkers)
+
+	me.asyncMu.RLock()
+	defer me.asyncMu.RUnlock()
+	if me.asyncClosed {
+		me.dropped(accid, topics, payload, ErrClosed)
+		return ErrClosed
+	}
+
+	me.addPending()
+	select {
+	case me.asyncQueueOf(accid) <- asyncJob{accid: accid, topics: topics, payload: payload, cb: cb}:
+		return nil
+	default:
+		me.donePending(nil)
+		me.dropped(accid, topics, payload, ErrQueueFull)
+		return ErrQueueFull
+	}
+}
+
+// dropped hands an async message discarded without being sent to the drop
+// handler, from another goroutine so the caller isn't held
+func (me *Client) dropped(accid string, topics []string, payload []byte, reason error) {
+	if me.dropHandler != nil {
+		go me.dropHandler(Message{AccountID: accid, Topics: topics, Payload: payload}, reason)
+	}
+}
+
+// Flush waits until every message queued by SendAsync has been published
+// (the queue is empty and no worker is busy) and returns the errors of the
+// async sends that failed since the previous Flush. It returns ctx's error
+// if ctx is done first, the failures are then kept for the next Flush
+func (me *Client) Flush(ctx context.Context) error {
+	me.flushMu.Lock()
+	idle := me.idle
+	me.flushMu.Unlock()
+
+	if idle != nil {
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	me.flushMu.Lock()
+	errs, dropped := me.flushErrs, me.droppedErrs
+	me.flushErrs, me.droppedErrs = nil, 0
+	me.flushMu.Unlock()
+
+	if dropped > 0 {
+		errs = append(errs, fmt.Errorf("and %d more async sends failed", dropped))
+	}
+	return joinErrors(errs)
+}
+
+// addPending counts a message entering the async queue
+func (me *Client) addPending() {
+	me.flushMu.Lock()
+	defer me.flushMu.Unlock()
+	if me.pending == 0 {
+		me.idle = make(chan struct{})
+	}
+	me.pending++
+}
+
+// donePending counts a message leaving the async queue, with the result of
+// its publish
+func (me *Client) donePending(err error) {
+	me.flushMu.Lock()
+	defer me.flushMu.Unlock()
+	if err != nil {
+		if len(me.flushErrs) < maxFlushErrors {
+			me.flushErrs = append(me.flushErrs, err)
+		} else {
+			me.droppedErrs++
+		}
+	}
+
+	me.pending--
+	if me.pending == 0 {
+		close(me.idle)
+		me.idle = nil
+	}
+}
+
+// newAsyncQueues makes the queues of the async workers. With
+// WithOrderedDelivery every worker has its own queue, which holds a share of
+// the queue size, and the messages of an account always go to the same one:
+// workers sharing a queue could publish them out of order
+func (me *Client) newAsyncQueues() []chan asyncJob {
+	if me.sequencer == nil {
+		return []chan asyncJob{make(chan asyncJob, me.asyncQueueSize)}
+	}
+
+	size := (me.asyncQueueSize + asyncWorkers - 1) / asyncWorkers
+	queues := make([]chan asyncJob, asyncWorkers)
+	for i := range queues {
+		queues[i] = make(chan asyncJob, size)
+	}
+	return queues
+}
+
+// asyncQueueOf returns the queue of the async sends of accid
+func (me *Client) asyncQueueOf(accid string) chan asyncJob {
+	if len(me.asyncQueues) == 1 {
+		return me.asyncQueues[0]
+	}
+	return me.asyncQueues[ShardIndex(accid, len(me.asyncQueues))]
+}
+
+func (me *Client) startAsyncWorkers() {
+	for i := 0; i < asyncWorkers; i++ {
+		me.asyncWg.Add(1)
+		go me.asyncWorker(me.asyncQueues[i%len(me.asyncQueues)])
+	}
+}
+
+func (me *Client) asyncWorker(queue chan asyncJob) {
+	defer me.asyncWg.Done()
+	for job := range queue {
+		err := me.SendContext(context.Background(), job.accid, job.topics, job.payload)
+		if errors.Is(err, ErrClosed) {
+			// Close gave up waiting for the queue to drain
+			me.dropped(job.accid, job.topics, job.payload, err)
+		}
+		if job.cb != nil {
+			job.cb(err)
+		}
+		me.donePending(err)
+	}
+}
+
+// drainAsync stops accepting async sends and waits until every queued one
+// has been delivered, or returns ErrDrainTimeout once ctx is done
+func (me *Client) drainAsync(ctx context.Context) error {
+	me.asyncMu.Lock()
+	if !me.asyncClosed {
+		me.asyncClosed = true
+		for _, queue := range me.asyncQueues {
+			close(queue)
+		}
+	}
+	me.asyncMu.Unlock()
+
+	// workers starting from now on would find the queue closed, but they must
+	// not be added to asyncWg while we wait on it
+	me.asyncOnce.Do(func() {})
+
+	done := make(chan struct{})
+	go func() {
+		me.asyncWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ErrDrainTimeout
+	}
+}
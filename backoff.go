@@ -0,0 +1,37 @@
+package client
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// NextBackoff returns how long to wait before retry number attempt, 1 being
+// the first retry: base, doubled after each retry, capped to max when max
+// is positive. It is the backoff of the client's own retries (WithRetry,
+// WithRetryPolicy), exported so retries around the client use the same
+// math
+func NextBackoff(attempt int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempt && d > 0 && d <= math.MaxInt64/2; i++ {
+		if max > 0 && d >= max {
+			break
+		}
+		d *= 2
+	}
+	if max > 0 && d > max {
+		d = max
+	}
+	return d
+}
+
+// WaitBackoff waits NextBackoff(attempt, base, max) on clock, e.g. a fake
+// clock in tests, and returns ctx's error if ctx is done first
+func WaitBackoff(ctx context.Context, clock Clock, attempt int, base, max time.Duration) error {
+	select {
+	case <-clock.After(NextBackoff(attempt, base, max)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,228 @@
+package client
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	pb "github.com/subiz/header/realtime"
+)
+
+// SendBatch publishes several messages for the same account. All of them go
+// to the same shard so they share a single connection, the publishes are
+// pipelined over it concurrently, see WithMaxConcurrency to bound them.
+// With WithOrderedDelivery they are published one after another instead, in
+// order, each taking its turn with the other sends of the account.
+// Messages without topics are skipped (or fail, see WithErrorOnEmptyTopics).
+// When some messages fail it returns a *MultiError keyed by message index
+func (me *Client) SendBatch(accid string, messages []Message) error {
+	return me.SendBatchContext(context.Background(), accid, messages)
+}
+
+// SendBatchContext is like SendBatch but every publish of the batch uses
+// ctx. Once ctx is done, the messages not sent yet fail with ctx's error
+// and the returned *MultiError tells which ones went through
+func (me *Client) SendBatchContext(ctx context.Context, accid string, messages []Message) error {
+	if len(messages) == 0 || !me.Enabled() {
+		return nil
+	}
+
+	if err := me.beginCall(); err != nil {
+		return err
+	}
+	defer me.inflight.Done()
+
+	if err := me.checkAccount(accid); err != nil {
+		return err
+	}
+
+	ctx = me.outgoingContext(ctx)
+	no, client, err := me.getPubsubClient(ctx, accid)
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, len(messages))
+	sent := 0
+	var wg sync.WaitGroup
+	for i, msg := range messages {
+		msg.Topics = me.expandTopics(msg.Topics)
+		if len(msg.Topics) == 0 {
+			errs[i] = me.emptyTopics(accid)
+			continue
+		}
+
+		if errs[i] = ctx.Err(); errs[i] != nil {
+			continue
+		}
+
+		msg.Topics = me.dedupTopics(msg.Topics)
+		if errs[i] = me.checkMessage(msg.Topics, msg.Payload); errs[i] != nil {
+			continue
+		}
+		me.warnSize(accid, msg.Payload)
+
+		if errs[i] = me.checkRateLimit(ctx, accid); errs[i] != nil {
+			continue
+		}
+
+		if errs[i] = me.acquireFanout(ctx); errs[i] != nil {
+			continue
+		}
+
+		mctx := msg.context(ctx)
+		publish := func(i int, msg Message) {
+			_, errs[i] = me.callPublish(me.withIdempotencyKey(mctx), no, client, &pb.PublishMessage{AccountId: accid, Payload: msg.Payload, Topics: msg.Topics})
+			me.recordShard(ctx, no, errs[i])
+		}
+		if me.sequencer != nil {
+			if err := me.sequencer.do(mctx, sequenceKey(mctx, accid), func() { sent++; publish(i, msg) }); err != nil {
+				errs[i] = err
+			}
+			me.releaseFanout()
+			continue
+		}
+
+		sent++
+		wg.Add(1)
+		go func(i int, msg Message) {
+			defer wg.Done()
+			defer me.releaseFanout()
+			publish(i, msg)
+		}(i, msg)
+	}
+	wg.Wait()
+	if sent == 0 {
+		me.skipShard(no)
+	}
+
+	failed := map[string]error{}
+	for i, err := range errs {
+		if err != nil {
+			failed[strconv.Itoa(i)] = err
+		}
+	}
+	if len(failed) > 0 {
+		return &MultiError{Errors: failed}
+	}
+	return nil
+}
+
+// SendMulti publishes the same payload to several accounts. Accounts are
+// grouped by shard, shards are sent to in parallel while the accounts of a
+// shard are sent one after another, so at most maxNodes publishes (or the
+// WithMaxConcurrency limit if lower) are in flight. When some accounts fail
+// it returns a *MultiError keyed by account ID
+func (me *Client) SendMulti(accids []string, topics []string, payload []byte) error {
+	return me.SendMultiContext(context.Background(), accids, topics, payload)
+}
+
+// SendMultiContext is like SendMulti but every publish uses ctx. Once ctx is
+// done, the accounts not sent to yet fail with ctx's error
+func (me *Client) SendMultiContext(ctx context.Context, accids []string, topics []string, payload []byte) error {
+	if len(accids) == 0 || (len(me.expandTopics(topics)) == 0 && !me.strictTopics) {
+		return nil
+	}
+
+	var mu sync.Mutex
+	failed := map[string]error{}
+	byShard := map[int][]string{}
+	for _, accid := range accids {
+		no, err := me.shardOf(accid)
+		if err != nil {
+			failed[accid] = err
+			continue
+		}
+		byShard[no] = append(byShard[no], accid)
+	}
+
+	var wg sync.WaitGroup
+	for _, accids := range byShard {
+		wg.Add(1)
+		go func(accids []string) {
+			defer wg.Done()
+			for _, accid := range accids {
+				err := me.acquireFanout(ctx)
+				if err == nil {
+					err = me.SendContext(ctx, accid, topics, payload)
+					me.releaseFanout()
+				}
+				if err != nil {
+					mu.Lock()
+					failed[accid] = err
+					mu.Unlock()
+				}
+			}
+		}(accids)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return &MultiError{Errors: failed}
+	}
+	return nil
+}
+
+// Broadcast publishes the same payload to every shard, whatever the account
+// routing, e.g. for a system wide announcement. It returns a *MultiError
+// keyed by shard index when some shards fail
+func (me *Client) Broadcast(topics []string, payload []byte) error {
+	return me.BroadcastContext(context.Background(), topics, payload)
+}
+
+// BroadcastContext is like Broadcast but every publish uses ctx. The
+// publishes are sent concurrently, within the WithMaxConcurrency limit.
+// They carry no account ID, like SendToShard there is no fallback to other
+// shards
+func (me *Client) BroadcastContext(ctx context.Context, topics []string, payload []byte) error {
+	shards := len(me.primaryShards())
+	errs := make([]error, shards)
+	var wg sync.WaitGroup
+	for no := 0; no < shards; no++ {
+		wg.Add(1)
+		go func(no int) {
+			defer wg.Done()
+			if errs[no] = me.acquireFanout(ctx); errs[no] != nil {
+				return
+			}
+			defer me.releaseFanout()
+
+			ctx := context.WithValue(ctx, sendOptionsKey{}, &sendOptions{pinned: true, shard: no})
+			_, errs[no] = me.publish(ctx, "", topics, payload)
+		}(no)
+	}
+	wg.Wait()
+
+	failed := map[string]error{}
+	for no, err := range errs {
+		if err != nil {
+			failed[strconv.Itoa(no)] = err
+		}
+	}
+	if len(failed) > 0 {
+		return &MultiError{Errors: failed}
+	}
+	return nil
+}
+
+// acquireFanout takes a slot for a publish issued by a fan-out method,
+// waiting while WithMaxConcurrency publishes are already in flight
+func (me *Client) acquireFanout(ctx context.Context) error {
+	if me.fanout == nil {
+		return nil
+	}
+
+	select {
+	case me.fanout <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseFanout frees a slot taken by acquireFanout
+func (me *Client) releaseFanout() {
+	if me.fanout != nil {
+		<-me.fanout
+	}
+}
@@ -0,0 +1,54 @@
+package client_test
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	client "github.com/subiz/realtime-client"
+	"github.com/subiz/realtime-client/clienttest"
+)
+
+func TestSendBatchOrderedDelivery(t *testing.T) {
+	c, srv := clienttest.NewInMemory(client.WithOrderedDelivery())
+	defer srv.Close()
+	defer c.Close(context.Background())
+
+	const n = 50
+	messages := make([]client.Message, n)
+	for i := range messages {
+		messages[i] = client.Message{Topics: []string{"conversation"}, Payload: []byte("batch " + strconv.Itoa(i))}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			if err := c.Send("acc1", []string{"conversation"}, []byte("send "+strconv.Itoa(i))); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	if err := c.SendBatch("acc1", messages); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	next := map[string]int{"batch": 0, "send": 0}
+	for _, p := range srv.Published() {
+		// "batch 3" or "send 3"
+		fields := strings.Fields(string(p.Payload))
+		kind := fields[0]
+		if i, _ := strconv.Atoi(fields[1]); i != next[kind] {
+			t.Fatalf("got %q, want %s %d", p.Payload, kind, next[kind])
+		}
+		next[kind]++
+	}
+	if next["batch"] != n || next["send"] != n {
+		t.Fatalf("got %d batch and %d send messages, want %d of each", next["batch"], next["send"], n)
+	}
+}
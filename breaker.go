@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the shard while its circuit
+// breaker is open, see WithCircuitBreaker
+var ErrCircuitOpen = newLocalReject("realtime client: circuit open")
+
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// breaker is the circuit breaker of a shard. It opens after threshold
+// failures within window, rejects calls for cooldown, then lets a single
+// probe through: the probe's result closes or reopens it
+type breaker struct {
+	sync.Mutex
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	clock     Clock
+
+	state        int
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	probing      bool
+}
+
+func newBreaker(threshold int, window, cooldown time.Duration, clock Clock) *breaker {
+	return &breaker{threshold: threshold, window: window, cooldown: cooldown, clock: clock}
+}
+
+// allow tells whether a call may go through
+func (b *breaker) allow() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if b.clock.Now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = circuitHalfOpen
+		b.probing = true
+		return true
+	case circuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// record reports the result of a call let through by allow
+func (b *breaker) record(failed bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	now := b.clock.Now()
+	if b.state == circuitHalfOpen {
+		b.probing = false
+		if failed {
+			b.state, b.openedAt = circuitOpen, now
+		} else {
+			b.state, b.failures = circuitClosed, 0
+		}
+		return
+	}
+
+	if !failed {
+		b.failures = 0
+		return
+	}
+
+	if b.failures == 0 || now.Sub(b.firstFailure) > b.window {
+		b.failures, b.firstFailure = 0, now
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.state, b.openedAt = circuitOpen, now
+	}
+}
+
+// skip reports that a call let through by allow ended without a result,
+// e.g. because its caller gave up. A probe is let through again
+func (b *breaker) skip() {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.state == circuitHalfOpen {
+		b.probing = false
+	}
+}
+
+// allowShard fails fast with ErrCircuitOpen while the shard's breaker is open
+func (me *Client) allowShard(no int) error {
+	s, err := me.shardAt(no)
+	if err != nil {
+		return err
+	}
+
+	b := s.breaker
+	if b == nil || b.allow() {
+		return nil
+	}
+	return fmt.Errorf("%w: shard %d", ErrCircuitOpen, no)
+}
+
+// recordShard feeds the result of a call to shard no to its breaker. Only
+// errors that say the shard is unhealthy count as failures, a call whose
+// ctx ended tells nothing about the shard and isn't counted
+func (me *Client) recordShard(ctx context.Context, no int, err error) {
+	s, serr := me.shardAt(no)
+	if serr != nil || s.breaker == nil {
+		return
+	}
+
+	b := s.breaker
+	if err != nil && ctx.Err() != nil {
+		b.skip()
+		return
+	}
+	b.record(errors.Is(err, ErrDialFailed) || isRetryable(err))
+}
+
+// skipShard reports to the breaker of shard no that the call it let through
+// sent nothing
+func (me *Client) skipShard(no int) {
+	if s, err := me.shardAt(no); err == nil && s.breaker != nil {
+		s.breaker.skip()
+	}
+}
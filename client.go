@@ -2,85 +2,826 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"hash/crc32"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/subiz/header"
 	pb "github.com/subiz/header/realtime"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
 )
 
+const defaultDialTimeout = 120 * time.Second
+
+// ErrClosed is returned when sending through a client that has been closed
+var ErrClosed = newLocalReject("realtime client closed")
+
+// ErrInvalidService is returned when the service string isn't in host:port
+// form, e.g. when the port is missing
+var ErrInvalidService = errors.New("realtime client: invalid service")
+
+// ErrDrainTimeout is returned by Close when queued messages weren't all
+// delivered before its context was done
+var ErrDrainTimeout = errors.New("realtime client: drain timed out")
+
+// shard holds the connection to a single realtime node. Each shard has its
+// own lock, which is never held while dialing, so a slow dial to one node
+// doesn't block sends to the others. Sends over an established connection
+// don't take the lock at all, they read live instead
+type shard struct {
+	// first for 64-bit alignment
+	sends    uint64 // publishes sent through the shard, see Stats
+	lastUsed int64  // unix nanoseconds of the last publish, see WithMaxIdleTime
+
+	sync.Mutex
+	conn    *grpc.ClientConn
+	client  header.PubsubClient
+	addr    string           // address conn was dialed at
+	since   time.Time        // when conn was established
+	expires time.Time        // when conn gets recycled, zero for never
+	watched *grpc.ClientConn // last conn watched for ConnectionEvents
+	live    atomic.Value     // *liveConn, mirrors conn and client, see set
+
+	removed   bool     // set by SetMaxNodes when the shard no longer exists
+	unhealthy int32    // 1 while marked unhealthy, see MarkShardUnhealthy
+	recycling int32    // 1 while a new connection is dialed, see maybeRecycle
+	breaker   *breaker // nil unless WithCircuitBreaker is set
+}
+
+// liveConn is an established connection of a shard
+type liveConn struct {
+	conn    *grpc.ClientConn
+	client  header.PubsubClient
+	expires time.Time
+}
+
+// set replaces the connection of the shard, established at since and to be
+// recycled at expires. The caller must hold the shard lock
+func (s *shard) set(conn *grpc.ClientConn, since, expires time.Time) {
+	s.conn, s.client = conn, header.NewPubsubClient(conn)
+	s.since, s.expires = since, expires
+	s.live.Store(&liveConn{conn: s.conn, client: s.client, expires: expires})
+}
+
+// drop forgets the connection of the shard, it doesn't close it. The caller
+// must hold the shard lock
+func (s *shard) drop() {
+	s.conn, s.client = nil, nil
+	s.since, s.expires = time.Time{}, time.Time{}
+	s.live.Store((*liveConn)(nil))
+}
+
+// postpone moves the recycling of the connection to expires. The caller
+// must hold the shard lock
+func (s *shard) postpone(expires time.Time) {
+	s.expires = expires
+	s.live.Store(&liveConn{conn: s.conn, client: s.client, expires: expires})
+}
+
+// load returns the connection of the shard without locking, nil when it
+// isn't connected
+func (s *shard) load() *liveConn {
+	lc, _ := s.live.Load().(*liveConn)
+	return lc
+}
+
 // Client helps you send message to realtime service easier
 type Client struct {
-	sync.Mutex
-	clients []header.PubsubClient
+	nodesMu sync.Mutex         // serializes SetMaxNodes
+	nodes   atomic.Value       // *nodes
+	closed  int32              // set to 1 by Close once nothing is in flight
+	dials   singleflight.Group // keyed by shard index, and by "addr " + address
+	opts    []ClientOption     // the options given to NewClient, see Clone
+	pool    *connPool
+	stats   *counters
+
+	mu       sync.RWMutex
+	closing  bool           // set when Close starts, rejects new sends
+	inflight sync.WaitGroup // publishes that are running
+	disabled int32          // 1 while sends are turned off, see SetEnabled
+
+	creds           credentials.TransportCredentials // nil means insecure
+	keepalive       *keepalive.ClientParameters      // nil means no pings
+	connectBackoff  *backoff.Config                  // nil means grpc's default
+	windowSize      int32                            // 0 means grpc's default
+	connWindowSize  int32                            // 0 means grpc's default
+	dialOptions     []grpc.DialOption
+	userAgent       string
+	interceptors    []grpc.UnaryClientInterceptor
+	dialTimeout     time.Duration
+	dialAttempts    int
+	dialDelay       time.Duration
+	maxConnAge      time.Duration
+	reconnectJitter bool // randomize dial retries and recycles
+	nonBlocking     bool
+	discovery       bool
+	lbPolicy        string
+	shared          bool // a single connection for all accounts
+
+	shardFunc        ShardFunc
+	mirrorFunc       ShardFunc // nil unless WithMirrorRouting is set
+	mirrorNodes      int
+	weights          []int
+	addressFormatter AddressFormatter
+	podPort          string // replaces the service's port in shard addresses
+	topicRouting     bool
+	fallback         bool
+	remapMissing     bool
+	fallbackHops     int
 
-	service  string // eg: realtime:48883
-	maxNodes int
+	callOptions     []grpc.CallOption
+	publishTimeout  time.Duration
+	sequencer       *sequencer // nil unless WithOrderedDelivery is set
+	metadata        metadata.MD
+	idempotencyKeys bool
+	accountIDHeader string // empty to not send the account ID as metadata
+	checksum        bool
+	defaultTopics   []string
+	serializer      Serializer
+	topicPrefix     string
+	strictTopics    bool // ErrNoTopics instead of skipping messages without topics
+	ackMode         AckMode
+
+	logger          Logger
+	logLimit        *logLimiter
+	metrics         Metrics
+	publishHook     PublishHook
+	contextFunc     func(ctx context.Context, accid string) context.Context
+	observer        func(PublishEvent)
+	connectCallback func(shard int, addr string, err error)
+	clock           Clock
+	loadLogInterval time.Duration
+	maxIdleTime     time.Duration
+	maxConns        int
+	done            chan struct{} // closed by Close to stop the background loops
+	ready           chan struct{} // closed by checkReady, see Ready
+	readyOnce       sync.Once
+	readyAll        bool
+	doneOnce        sync.Once
+
+	retryAttempts int
+	retryBackoff  time.Duration
+	retryPolicy   map[codes.Code]RetryRule // replaces the two above when set
+
+	sizeWarn         int
+	sizeWarnFunc     func(accid string, size int)
+	maxPayloadSize   int
+	maxTopics        int
+	topicValidator   func(topic string) error
+	accountValidator func(accid string) error
+	topicDedup       bool
+
+	rateLimit     rate.Limit
+	rateBurst     int
+	rateLimitWait bool
+	limiters      *limiters // nil when there is no rate limit
+
+	breakerThreshold int
+	breakerWindow    time.Duration
+	breakerCooldown  time.Duration
+
+	maxConcurrency int
+	fanout         chan struct{} // semaphore of fan-out publishes, nil if unbounded
+
+	dropHandler    func(msg Message, reason error)
+	store          Store // nil unless WithDurableBuffer is set
+	storeInherited bool  // store is the one of the client this one was cloned from, which resends it
+	asyncQueueSize int
+	asyncQueues    []chan asyncJob // one per worker with WithOrderedDelivery, else shared
+	asyncOnce      sync.Once
+	asyncWg        sync.WaitGroup
+	asyncMu        sync.RWMutex
+	asyncClosed    bool
+
+	releasesMu sync.Mutex
+	releases   map[*time.Timer]*grpc.ClientConn // connections released after recycleGrace
+
+	eventsOnce   sync.Once
+	eventsMu     sync.RWMutex
+	eventsOn     int32 // 1 once ConnectionEvents has been called
+	events       chan ConnEvent
+	eventsClosed bool
+
+	flushMu     sync.Mutex
+	pending     int           // async messages not published yet
+	idle        chan struct{} // closed when pending drops to 0, nil while 0
+	flushErrs   []error       // async failures since the last Flush
+	droppedErrs int           // failures past maxFlushErrors
 }
 
 // NewClient creates a new Client service
 // Notes: we don't connect to all realtime service right away because it
 // may blocks start up flow
-func NewClient(service string, maxNodes int) *Client {
-	return &Client{
-		service:  service,
-		maxNodes: maxNodes,
-		clients:  make([]header.PubsubClient, maxNodes, maxNodes),
+// It panics if service or maxNodes is invalid, use NewClientWithError to get
+// an error instead
+func NewClient(service string, maxNodes int, opts ...ClientOption) *Client {
+	me, err := NewClientWithError(service, maxNodes, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return me
+}
+
+// NewClientWithError is like NewClient but returns an error when service is
+// not in name:port form or maxNodes is less than 1
+func NewClientWithError(service string, maxNodes int, opts ...ClientOption) (*Client, error) {
+	if maxNodes < 1 {
+		return nil, fmt.Errorf("realtime client: maxNodes must be at least 1, got %d", maxNodes)
+	}
+
+	if _, _, err := splitService(service); err != nil {
+		return nil, err
+	}
+
+	me := &Client{
+		opts:     opts,
+		pool:     newConnPool(),
+		logLimit: newLogLimiter(),
+		stats:    &counters{},
+
+		accountIDHeader: AccountIDHeader,
+		serializer:      JSONSerializer{},
+
+		dialTimeout: defaultDialTimeout,
+		userAgent:   defaultUserAgent,
+		shardFunc:   ShardIndex,
+		logger:      nopLogger{},
+		metrics:     nopMetrics{},
+		clock:       realClock{},
+
+		addressFormatter: statefulSetAddress,
+		fallbackHops:     1,
+
+		retryAttempts: 1,
+		dialAttempts:  1,
+
+		asyncQueueSize: defaultAsyncQueueSize,
+		releases:       map[*time.Timer]*grpc.ClientConn{},
+	}
+	for _, opt := range opts {
+		opt(me)
+	}
+	if me.weights != nil {
+		if err := checkWeights(me.weights, maxNodes); err != nil {
+			return nil, err
+		}
+		me.shardFunc = weightedShard(me.weights)
+	}
+	shards := make([]*shard, me.shardCount(maxNodes))
+	for i := range shards {
+		shards[i] = me.newShard()
+	}
+	me.nodes.Store(&nodes{shards: shards, maxNodes: maxNodes, service: service})
+	me.asyncQueues = me.newAsyncQueues()
+	if me.maxConcurrency > 0 {
+		me.fanout = make(chan struct{}, me.maxConcurrency)
+	}
+	if me.rateLimit > 0 {
+		me.limiters = newLimiters(me.rateLimit, me.rateBurst, me.clock)
 	}
+	me.done = make(chan struct{})
+	me.ready = make(chan struct{})
+	if me.loadLogInterval > 0 {
+		go me.logShardLoad()
+	}
+	if me.maxIdleTime > 0 {
+		go me.closeIdle()
+	}
+	if me.store != nil && !me.storeInherited {
+		go me.resendBuffered()
+	}
+	return me, nil
 }
 
-// Send delivers a payload to the correct realtime service
+// Close closes all connections to the realtime service. Messages already
+// queued by SendAsync are delivered first, waiting at most until ctx is done:
+// then the connections are closed anyway, failing what is still in flight,
+// and Close returns ErrDrainTimeout. Connections replaced or dropped
+// earlier, kept open for the publishes still using them, are closed too.
+// The client must not be used after Close, any later Send returns ErrClosed
+func (me *Client) Close(ctx context.Context) error {
+	var errs []error
+	if err := me.drainAsync(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	// called even when the async drain timed out so new sends get rejected
+	if err := me.drainInflight(ctx); err != nil && len(errs) == 0 {
+		errs = append(errs, err)
+	}
+	atomic.StoreInt32(&me.closed, 1)
+
+	for i, s := range me.shardList() {
+		s.Lock()
+		if s.conn != nil {
+			if err := me.pool.release(s.conn); err != nil {
+				errs = append(errs, fmt.Errorf("shard %d: %v", i, err))
+			}
+			me.metrics.SetConnected(i, false)
+		}
+		s.drop()
+		s.Unlock()
+	}
+	errs = append(errs, me.flushReleases()...)
+	me.closeEvents()
+	me.doneOnce.Do(func() { close(me.done) })
+	return joinErrors(errs)
+}
+
+// Send delivers a payload to the correct realtime service. It does nothing
+// when topics is empty. A nil payload is rejected with ErrNilPayload while a
+// non nil empty one is published as an empty message
 func (me *Client) Send(accid string, topics []string, payload []byte) error {
+	return me.SendContext(context.Background(), accid, topics, payload)
+}
+
+// SendContext is like Send but uses ctx for the publish RPC, so callers can
+// set a deadline or cancel an in-flight publish
+func (me *Client) SendContext(ctx context.Context, accid string, topics []string, payload []byte) error {
+	return me.PublishContext(ctx, Message{AccountID: accid, Topics: topics, Payload: payload})
+}
+
+// SendWithResponse is like Send but also returns the message the realtime
+// service replied with. The response is nil when topics is empty since
+// nothing is published
+func (me *Client) SendWithResponse(accid string, topics []string, payload []byte) (proto.Message, error) {
+	out, err := me.publish(context.Background(), accid, topics, payload)
+	return out.res, err
+}
+
+// TopicResult is the outcome of a publish for one of its topics, see
+// SendDetailed
+type TopicResult struct {
+	Topic string
+	Sent  bool  // whether the realtime service accepted the message
+	Err   error // why it wasn't, nil when nothing was sent (e.g. SetEnabled)
+}
+
+// SendDetailed is like Send but also reports the result of every topic the
+// message went to, default topics included. The realtime service replies
+// to a publish as a whole, it has no per topic report (e.g. topics without
+// subscribers) yet: until it does, every topic gets the result of the
+// publish. The results are nil when topics is empty
+func (me *Client) SendDetailed(ctx context.Context, accid string, topics []string, payload []byte) ([]TopicResult, error) {
+	out, err := me.publish(ctx, accid, topics, payload)
+
+	all := me.dedupTopics(me.expandTopics(topics))
+	if len(all) == 0 {
+		return nil, err
+	}
+
+	results := make([]TopicResult, len(all))
+	for i, topic := range all {
+		results[i] = TopicResult{Topic: topic, Sent: err == nil && out.shard >= 0, Err: err}
+	}
+	return results, err
+}
+
+// SendReportShard is like Send but also returns the shard the message was
+// routed to, which may differ from the account's shard with WithFallback.
+// The shard is -1 when the message wasn't routed (e.g. it was rejected by a
+// client side check or topics is empty)
+func (me *Client) SendReportShard(accid string, topics []string, payload []byte) (shard int, err error) {
+	out, err := me.publish(context.Background(), accid, topics, payload)
+	return out.shard, err
+}
+
+// Result describes a publish, see SendWithResult
+type Result struct {
+	Duration time.Duration // the dial, if one was needed, and the publish RPCs
+	Bytes    int           // size of the encoded publish request, 0 if not sent
+	Shard    int           // -1 when the message wasn't routed
+	Err      error
+}
+
+// SendWithResult is like SendContext but also reports how long the
+// publish took and how many bytes it sent. Client side checks, rate limits
+// and waiting for the account's earlier messages (WithOrderedDelivery)
+// aren't timed. Result.Err is the returned error
+func (me *Client) SendWithResult(ctx context.Context, accid string, topics []string, payload []byte) (Result, error) {
+	out, err := me.publish(ctx, accid, topics, payload)
+	return Result{Duration: out.took, Bytes: out.bytes, Shard: out.shard, Err: err}, err
+}
+
+// outcome is what the client knows about a publish once it is over
+type outcome struct {
+	res   proto.Message // the reply of the realtime service
+	shard int           // -1 when the message wasn't routed
+	bytes int           // size of the encoded publish request, 0 if not sent
+	took  time.Duration // spent connecting to the shard and publishing
+}
+
+func (me *Client) publish(ctx context.Context, accid string, topics []string, payload []byte) (out outcome, err error) {
+	out.shard = -1
+	if me.store != nil {
+		original := topics
+		defer func() {
+			if err != nil {
+				err = me.spill(ctx, accid, original, payload, err)
+			}
+		}()
+	}
+	topics = me.expandTopics(topics)
 	if len(topics) == 0 {
+		return out, me.emptyTopics(accid)
+	}
+	if !me.Enabled() {
+		return out, nil
+	}
+
+	if err := me.beginCall(); err != nil {
+		return out, err
+	}
+	defer me.inflight.Done()
+
+	if !sendOptionsOf(ctx).pinned {
+		// pinned sends aren't routed by account: Broadcast has none and
+		// SendToShard checks its own
+		if err := me.checkAccount(accid); err != nil {
+			return out, err
+		}
+	}
+
+	topics = me.dedupTopics(topics)
+	if err := me.checkMessage(topics, payload); err != nil {
+		return out, err
+	}
+	me.warnSize(accid, payload)
+
+	if err := ctx.Err(); err != nil {
+		return out, err
+	}
+
+	if err := me.checkRateLimit(ctx, accid); err != nil {
+		return out, err
+	}
+
+	if me.sequencer != nil {
+		if serr := me.sequencer.do(ctx, sequenceKey(ctx, accid), func() { out, err = me.deliver(ctx, accid, topics, payload) }); serr != nil {
+			return out, serr
+		}
+		return out, err
+	}
+	return me.deliver(ctx, accid, topics, payload)
+}
+
+// deliver routes a message, that passed the client side checks, to its
+// shard and publishes it
+func (me *Client) deliver(ctx context.Context, accid string, topics []string, payload []byte) (out outcome, err error) {
+	out.shard = -1
+	o := sendOptionsOf(ctx)
+	no := o.shard
+	if !o.pinned {
+		if !o.routed {
+			if no, err = me.shardOf(me.routingKey(o, accid, topics)); err != nil {
+				return out, err
+			}
+		}
+
+		if mirrorc := me.startMirror(ctx, no, accid, topics, payload); mirrorc != nil {
+			// runs after the publish hook's done, which only sees the primary
+			defer func() { err = joinMirror(err, <-mirrorc) }()
+		}
+	}
+
+	if me.publishHook != nil {
+		var done func(error)
+		ctx, done = me.publishHook(ctx, PublishInfo{AccountID: accid, Shard: no, Topics: len(topics), PayloadSize: len(payload)})
+		defer func() { done(err) }()
+	}
+
+	// the dial, if any, counts as part of the publish
+	start := me.clock.Now()
+	defer func() { out.took = me.clock.Now().Sub(start) }()
+
+	var client header.PubsubClient
+	if o.pinned {
+		client, err = me.tryShard(ctx, no)
+	} else {
+		no, client, err = me.connectShard(ctx, no)
+		if err != nil && me.remapMissing && errors.Is(err, ErrShardUnavailable) {
+			no, client, err = me.remapShard(ctx, accid, no, err)
+		}
+	}
+	if err != nil {
+		return out, err
+	}
+
+	out.shard = no
+	msg := &pb.PublishMessage{AccountId: accid, Payload: payload, Topics: topics}
+	out.bytes = proto.Size(msg)
+	out.res, err = me.callPublish(me.withIdempotencyKey(me.outgoingContext(ctx)), no, client, msg)
+	me.recordShard(ctx, no, err)
+	return out, err
+}
+
+// SetEnabled turns publishing on or off at runtime. While disabled, sends
+// succeed without dialing or publishing anything, e.g. during a maintenance
+// window. It is safe to call concurrently with sends
+func (me *Client) SetEnabled(enabled bool) {
+	var v int32 = 1
+	if enabled {
+		v = 0
+	}
+	if atomic.SwapInt32(&me.disabled, v) != v {
+		me.logger.Printf("realtime client: publishing enabled: %v", enabled)
+	}
+}
+
+// Enabled tells whether the client publishes, see SetEnabled
+func (me *Client) Enabled() bool {
+	return atomic.LoadInt32(&me.disabled) == 0
+}
+
+// beginCall registers a publish so Close waits for it. It fails with
+// ErrClosed once Close has started. Callers must call inflight.Done when
+// the publish is over
+func (me *Client) beginCall() error {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	if me.closing {
+		return ErrClosed
+	}
+	me.inflight.Add(1)
+	return nil
+}
+
+// drainInflight rejects new sends and waits until the running ones are
+// over, or returns ErrDrainTimeout once ctx is done
+func (me *Client) drainInflight(ctx context.Context) error {
+	me.mu.Lock()
+	me.closing = true
+	me.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		me.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
 		return nil
+	case <-ctx.Done():
+		return ErrDrainTimeout
+	}
+}
+
+// getPubsubClient returns the correct pubsubClient for an account ID and
+// the shard it belongs to. The returned client must be ready to be used,
+// it fails with ErrCircuitOpen while the shard's breaker is open
+func (me *Client) getPubsubClient(ctx context.Context, accid string) (int, header.PubsubClient, error) {
+	no, err := me.shardOf(accid)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	client, err := me.tryShard(ctx, no)
+	if err != nil {
+		return 0, nil, err
+	}
+	return no, client, nil
+}
+
+// shardClient returns a ready client for shard no, connecting to it if
+// needed. Concurrent first dials of the same shard are coalesced into a
+// single dial while other shards dial in parallel. The dial is bounded by
+// the context of the caller that started it, other callers stop waiting as
+// soon as their own ctx is done
+func (me *Client) shardClient(ctx context.Context, no int) (header.PubsubClient, error) {
+	retried := false
+	for {
+		client, err := me.cachedClient(no)
+		if err != nil || client != nil {
+			return client, err
+		}
+
+		ch := me.dials.DoChan(strconv.Itoa(no), func() (interface{}, error) {
+			return me.dialShard(ctx, no)
+		})
+
+		select {
+		case res := <-ch:
+			if res.Err == nil {
+				return res.Val.(header.PubsubClient), nil
+			}
+			// the dial was started by another caller whose context ended,
+			// dial once more with ours
+			if res.Shared && !retried && ctx.Err() == nil && isContextError(res.Err) {
+				retried = true
+				continue
+			}
+			if ctx.Err() != nil && isContextError(res.Err) {
+				// report the caller giving up rather than a failed dial
+				return nil, ctx.Err()
+			}
+			return nil, res.Err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// cachedClient returns the client already connected to shard no, or nil if
+// the shard must be dialed
+func (me *Client) cachedClient(no int) (header.PubsubClient, error) {
+	s, err := me.shardAt(no)
+	if err != nil {
+		return nil, err
+	}
+
+	// fast path, the shard is connected
+	if lc := s.load(); lc != nil && atomic.LoadInt32(&me.closed) == 0 && !isBroken(lc.conn) {
+		me.maybeRecycle(no, s, lc.expires)
+		return lc.client, nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if atomic.LoadInt32(&me.closed) == 1 {
+		return nil, ErrClosed
+	}
+
+	if s.removed {
+		return nil, fmt.Errorf("%w: %d", ErrShardRemoved, no)
+	}
+
+	if s.client == nil {
+		return nil, nil
 	}
 
-	client, err := me.getPubsubClient(accid)
+	if !isBroken(s.conn) {
+		me.maybeRecycle(no, s, s.expires)
+		return s.client, nil
+	}
+	// the node behind this connection has gone away (e.g. the pod was
+	// restarted), drop it and dial again
+	me.pool.release(s.conn)
+	s.drop()
+	me.metrics.SetConnected(no, false)
+	return nil, nil
+}
+
+// dropClient throws away the connection of shard no if client is still the
+// one cached and grpc no longer reports it ready, so the next send dials
+// again. It is called when a publish fails with Unavailable: one coming
+// over a ready connection (e.g. an overloaded server) keeps it. The
+// connection is released after recycleGrace so the other publishes running
+// over it can finish
+func (me *Client) dropClient(no int, client header.PubsubClient) {
+	s, err := me.shardAt(no)
+	if err != nil {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.client == nil || s.client != client || s.conn.GetState() == connectivity.Ready {
+		return
+	}
+
+	me.logger.Printf("realtime client: dropping the connection of shard %d to %s", no, s.addr)
+	me.pool.forget(s.conn)
+	me.releaseLater(s.conn)
+	s.drop()
+	me.metrics.SetConnected(no, false)
+}
+
+// Reconnect closes the connection of a shard, if any, and dials a new one,
+// e.g. to recover a wedged shard by hand. Publishes running over the old
+// connection fail, unless other shards at the same address still use it.
+// It returns the dial error, the dial is bounded by the dial timeout
+func (me *Client) Reconnect(shard int) error {
+	s, err := me.shardAt(shard)
 	if err != nil {
 		return err
 	}
-	ctx := context.Background()
 
-	_, err = client.Publish(ctx, &pb.PublishMessage{AccountId: accid, Payload: payload, Topics: topics})
+	s.Lock()
+	if s.conn != nil {
+		me.pool.forget(s.conn)
+		me.pool.release(s.conn)
+		s.drop()
+		me.metrics.SetConnected(shard, false)
+	}
+	s.Unlock()
+
+	_, err = me.shardClient(context.Background(), shard)
 	return err
 }
 
-// getPubsubClient returns the correct pubsubClient for an account ID
-// the returned client must be ready to be used
-func (me *Client) getPubsubClient(accid string) (header.PubsubClient, error) {
-	no := int(crc32.ChecksumIEEE([]byte(accid))) % me.maxNodes
-
-	me.Lock()
-	if me.clients[no] != nil {
-		me.Unlock()
-		return me.clients[no], nil
+// dialShard connects to shard no and stores the connection. The shard lock
+// is not held while dialing so the shard stays readable meanwhile
+func (me *Client) dialShard(ctx context.Context, no int) (header.PubsubClient, error) {
+	// a dial that finished just before this one started may have already
+	// filled the shard
+	if client, err := me.cachedClient(no); err != nil || client != nil {
+		return client, err
 	}
 
-	defer me.Unlock()
+	s, err := me.shardAt(no)
+	if err != nil {
+		return nil, err
+	}
 
-	parts := strings.SplitN(me.service, ":", 2)
-	name, port := parts[0], parts[1]
-	// address: [pod name] + "." + [service name] + ":" + [pod port]
-	conn, err := dialGrpc(name + "-" + strconv.Itoa(no) + "." + name + ":" + port)
+	addr, err := me.address(no)
+	if err != nil {
+		return nil, err
+	}
+	me.emit(no, ConnDialing)
+	conn, err := me.openConn(ctx, no, addr)
 	if err != nil {
-		fmt.Println("unable to connect to pubsub service", err)
+		if missingHost(addr) {
+			err = fmt.Errorf("%w: %s doesn't resolve: %v", ErrShardUnavailable, addr, err)
+		}
+		err = &DialError{Shard: no, Addr: addr, Err: err}
+		me.emit(no, ConnFailed)
+		me.notifyConnect(no, addr, err)
 		return nil, err
 	}
-	me.clients[no] = header.NewPubsubClient(conn)
-	return me.clients[no], nil
+
+	client, err := me.storeConn(s, no, addr, conn)
+	if err == nil {
+		// outside the shard lock so the callback may use the client
+		me.notifyConnect(no, addr, nil)
+		me.checkReady()
+		me.evictLRU(no)
+	}
+	return client, err
+}
+
+// storeConn caches conn as the connection of shard s, or releases it when
+// the client or the shard is gone
+func (me *Client) storeConn(s *shard, no int, addr string, conn *grpc.ClientConn) (header.PubsubClient, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	// checked under the shard lock so we never store a connection after Close
+	// or SetMaxNodes has walked past this shard
+	if atomic.LoadInt32(&me.closed) == 1 {
+		me.pool.release(conn)
+		return nil, ErrClosed
+	}
+
+	if s.removed {
+		me.pool.release(conn)
+		return nil, fmt.Errorf("%w: %d", ErrShardRemoved, no)
+	}
+
+	now := me.clock.Now()
+	s.set(conn, now, me.connExpiry(now))
+	s.addr = addr
+	me.metrics.SetConnected(no, true)
+	me.watchConn(no, s)
+	me.watchDrain(no, s)
+	return s.client, nil
+}
+
+// notifyConnect tells the connect callback, if any, that a dial of shard no
+// is over
+func (me *Client) notifyConnect(no int, addr string, err error) {
+	if me.connectCallback != nil {
+		me.connectCallback(no, addr, err)
+	}
+}
+
+// splitService splits a service string such as realtime:48883 into its name
+// and port
+func splitService(service string) (name, port string, err error) {
+	parts := strings.SplitN(service, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("%w: service %q must be in host:port form", ErrInvalidService, service)
+	}
+	return parts[0], parts[1], nil
 }
 
-func dialGrpc(service string) (*grpc.ClientConn, error) {
-	var opts []grpc.DialOption
-	opts = append(opts, grpc.WithInsecure())
-	// Enabling WithBlock tells the client to not give up trying to find a server
-	opts = append(opts, grpc.WithBlock())
-	// However, we're still setting a timeout so that if the server takes too long, we still give up
-	opts = append(opts, grpc.WithTimeout(120*time.Second))
-	return grpc.Dial(service, opts...)
+// Clone returns a new client to the same service, with the same number of
+// nodes, built with the options of this client followed by opts, e.g. to
+// give a subsystem its own publish timeout, logger or metrics. The clone
+// shares this client's connections: a shard dialed at an address this
+// client is connected to reuses the connection, so opts shouldn't change
+// how connections are dialed (credentials, dial options, ...). The clone
+// also spills to this client's durable buffer, if any, but only this client
+// resends what is buffered, unless opts give the clone a store of its own.
+// Each client must be closed on its own, a connection is closed once no
+// client uses it. It panics like NewClient if the options are invalid
+func (me *Client) Clone(opts ...ClientOption) *Client {
+	cur := me.nodes.Load().(*nodes)
+	all := append(me.opts[:len(me.opts):len(me.opts)], func(clone *Client) { clone.storeInherited = clone.store != nil })
+	all = append(all, opts...)
+	all = append(all, func(clone *Client) { clone.pool = me.pool })
+	return NewClient(cur.service, cur.maxNodes, all...)
 }
@@ -0,0 +1,81 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	client "github.com/subiz/realtime-client"
+	"github.com/subiz/realtime-client/clienttest"
+	"google.golang.org/grpc"
+)
+
+func TestSendContextCancelledMidDial(t *testing.T) {
+	dialing := make(chan struct{}, 1)
+	// never connects, until grpc gives up on the dial
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		select {
+		case dialing <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	c := client.NewClient("realtime:8080", 1, client.WithDialOptions(grpc.WithContextDialer(dialer)))
+	defer c.Close(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-dialing
+		cancel()
+	}()
+
+	start := time.Now()
+	err := c.SendContext(ctx, "acc1", []string{"conversation"}, []byte(`{"id":1}`))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	// the dial timeout is 2 minutes
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("SendContext returned after %v", d)
+	}
+}
+
+// BenchmarkSend measures the hot path of a send to an already connected
+// shard, where the connection is read without taking the shard lock
+func BenchmarkSend(b *testing.B) {
+	c, srv := clienttest.NewInMemory()
+	defer srv.Close()
+	defer c.Close(context.Background())
+
+	topics, payload := []string{"conversation"}, []byte(`{"id":1}`)
+	// dial outside of the measure
+	if err := c.Send("acc1", topics, payload); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("serial", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := c.Send("acc1", topics, payload); err != nil {
+				b.Fatal(err)
+			}
+		}
+		srv.Reset()
+	})
+
+	b.Run("parallel", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if err := c.Send("acc1", topics, payload); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+		srv.Reset()
+	})
+}
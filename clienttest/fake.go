@@ -0,0 +1,83 @@
+// Package clienttest provides helpers to test code that publishes through the
+// realtime client
+package clienttest
+
+import (
+	"context"
+	"sync"
+
+	client "github.com/subiz/realtime-client"
+)
+
+// Published is a message recorded by FakePublisher
+type Published struct {
+	AccountID string
+	Topics    []string
+	Payload   []byte
+}
+
+// FakePublisher is a client.Publisher that records what is sent instead of
+// publishing it. The zero value is ready to use
+type FakePublisher struct {
+	mu        sync.Mutex
+	err       error
+	published []Published
+}
+
+var _ client.Publisher = (*FakePublisher)(nil)
+
+// Send records the message, see SetError
+func (f *FakePublisher) Send(accid string, topics []string, payload []byte) error {
+	return f.SendContext(context.Background(), accid, topics, payload)
+}
+
+// SendContext records the message, see SetError. Like the real client it
+// ignores messages without topics and rejects nil payloads
+func (f *FakePublisher) SendContext(ctx context.Context, accid string, topics []string, payload []byte) error {
+	if len(topics) == 0 {
+		return nil
+	}
+
+	if payload == nil {
+		return client.ErrNilPayload
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+
+	f.published = append(f.published, Published{
+		AccountID: accid,
+		Topics:    append([]string(nil), topics...),
+		Payload:   append([]byte{}, payload...),
+	})
+	return nil
+}
+
+// SetError makes every later send fail with err (without being recorded),
+// pass nil to make them succeed again
+func (f *FakePublisher) SetError(err error) {
+	f.mu.Lock()
+	f.err = err
+	f.mu.Unlock()
+}
+
+// Published returns a copy of the messages recorded so far, oldest first
+func (f *FakePublisher) Published() []Published {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Published(nil), f.published...)
+}
+
+// Reset forgets the recorded messages
+func (f *FakePublisher) Reset() {
+	f.mu.Lock()
+	f.published = nil
+	f.mu.Unlock()
+}
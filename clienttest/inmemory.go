@@ -0,0 +1,97 @@
+package clienttest
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+
+	pb "github.com/subiz/header/realtime"
+	client "github.com/subiz/realtime-client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const inMemoryBufferSize = 1 << 20
+
+// InMemory is a fake realtime service served in memory over bufconn, it
+// records every message published to it. See NewInMemory
+type InMemory struct {
+	lis *bufconn.Listener
+	srv *grpc.Server
+
+	mu        sync.Mutex
+	err       error
+	published []Published
+}
+
+// NewInMemory starts an in-memory realtime service and returns a real
+// client connected to it, built with opts, along with the service to read
+// what it received. Every shard of the client is served by the same fake
+// service. Close the client, then the service, when done
+func NewInMemory(opts ...client.ClientOption) (*client.Client, *InMemory) {
+	m := &InMemory{lis: bufconn.Listen(inMemoryBufferSize)}
+	m.srv = grpc.NewServer(grpc.UnknownServiceHandler(m.handle))
+	go m.srv.Serve(m.lis)
+
+	dialer := func(context.Context, string) (net.Conn, error) { return m.lis.Dial() }
+	opts = append(opts, client.WithDialOptions(grpc.WithContextDialer(dialer)))
+	return client.NewClient("realtime:8080", 1, opts...), m
+}
+
+// handle serves every RPC sent to the fake service, only Publish is
+// implemented
+func (m *InMemory) handle(srv interface{}, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+	if !strings.HasSuffix(method, "/Publish") {
+		return status.Errorf(codes.Unimplemented, "clienttest: method %s not implemented", method)
+	}
+
+	msg := &pb.PublishMessage{}
+	if err := stream.RecvMsg(msg); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	err := m.err
+	if err == nil {
+		m.published = append(m.published, Published{AccountID: msg.AccountId, Topics: msg.Topics, Payload: msg.Payload})
+	}
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// a message without any field set encodes to nothing, which decodes
+	// into the empty reply the client expects
+	return stream.SendMsg(&pb.PublishMessage{})
+}
+
+// SetError makes every later publish fail with err (without being
+// recorded), e.g. status.Error(codes.Unavailable, ...) to exercise retries.
+// Pass nil to make them succeed again
+func (m *InMemory) SetError(err error) {
+	m.mu.Lock()
+	m.err = err
+	m.mu.Unlock()
+}
+
+// Published returns a copy of the messages received so far, oldest first
+func (m *InMemory) Published() []Published {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Published(nil), m.published...)
+}
+
+// Reset forgets the received messages
+func (m *InMemory) Reset() {
+	m.mu.Lock()
+	m.published = nil
+	m.mu.Unlock()
+}
+
+// Close stops the fake service
+func (m *InMemory) Close() {
+	m.srv.Stop()
+}
@@ -0,0 +1,17 @@
+package client
+
+import "time"
+
+// Clock is the time source of the client's retry backoffs, circuit breakers
+// and rate limits, see WithClock. Context deadlines, publish and dial
+// timeouts and WithRateLimitWait keep using the real time
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the default Clock
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
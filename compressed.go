@@ -0,0 +1,76 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/subiz/header/realtime"
+	"google.golang.org/grpc/metadata"
+)
+
+// BatchEncodingHeader is the metadata key marking a publish whose payload
+// is a compressed batch of messages, see SendBatchCompressed
+const BatchEncodingHeader = "x-batch-encoding"
+
+// batchEncoding is the value of BatchEncodingHeader: gzip over
+// length-delimited PublishMessages
+const batchEncoding = "gzip+delimited-proto"
+
+// SendBatchCompressed publishes messages of accid in a single Publish RPC.
+// The payload of that publish is the gzip compressed concatenation of the
+// messages, each a PublishMessage (account ID, topics and payload) encoded
+// with proto and prefixed with its length as an unsigned varint. The
+// publish is sent to the union of the messages' topics with
+// BatchEncodingHeader set to "gzip+delimited-proto": the realtime service
+// must recognize that header, decompress the payload and publish every
+// message on its own, otherwise subscribers receive the compressed blob.
+// Only topics and payloads are carried, the TTL, headers and other fields
+// of the messages are dropped. Every message goes through the client side
+// checks first, the whole batch fails if one of them is rejected. The
+// publish itself is checked too, e.g. WithMaxTopics applies to the union
+func (me *Client) SendBatchCompressed(accid string, messages []Message) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	var topics []string // before expandTopics, publish expands them again
+	seen := map[string]bool{}
+	count := 0
+	for i, msg := range messages {
+		expanded := me.dedupTopics(me.expandTopics(msg.Topics))
+		if len(expanded) == 0 {
+			continue
+		}
+		if err := me.checkMessage(expanded, msg.Payload); err != nil {
+			return fmt.Errorf("message %d: %w", i, err)
+		}
+
+		b, err := proto.Marshal(&pb.PublishMessage{AccountId: accid, Topics: expanded, Payload: msg.Payload})
+		if err != nil {
+			return localRejectf("realtime client: marshal message %d: %w", i, err)
+		}
+		var size [binary.MaxVarintLen64]byte
+		zw.Write(size[:binary.PutUvarint(size[:], uint64(len(b)))])
+		zw.Write(b)
+		count++
+
+		for _, topic := range msg.Topics {
+			if !seen[topic] {
+				seen[topic] = true
+				topics = append(topics, topic)
+			}
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return localRejectf("realtime client: compress batch: %w", err)
+	}
+	if count == 0 {
+		return nil
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), BatchEncodingHeader, batchEncoding)
+	_, err := me.publish(ctx, accid, topics, buf.Bytes())
+	return err
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// missingHostTimeout bounds the lookup telling a missing pod from one that
+// is down, see missingHost
+const missingHostTimeout = 2 * time.Second
+
+// minConnectTimeout is the least time given to a connection attempt, grpc's
+// default
+const minConnectTimeout = 20 * time.Second
+
+// isBroken tells whether conn should be thrown away and dialed again.
+// Connections in TRANSIENT_FAILURE or SHUTDOWN are rebuilt, IDLE and
+// CONNECTING are left to grpc since they may still become READY
+func isBroken(conn *grpc.ClientConn) bool {
+	state := conn.GetState()
+	return state == connectivity.TransientFailure || state == connectivity.Shutdown
+}
+
+// isContextError tells whether err comes from a cancelled or expired context
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// missingHost tells whether the host of addr doesn't exist in DNS, as
+// opposed to failing to answer. Targets with a scheme, like the dns:///
+// targets of WithServiceDiscovery, are never reported. The lookup has its
+// own timeout since the dial may have failed because its context ended
+func missingHost(addr string) bool {
+	if strings.Contains(addr, "://") {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), missingHostTimeout)
+	defer cancel()
+	_, err = net.DefaultResolver.LookupHost(ctx, host)
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// balancingPolicy returns the load balancing policy put in the default
+// service config, empty to leave it to grpc. In discovery mode it defaults
+// to round_robin to spread calls over every address the service name
+// resolves to
+func (me *Client) balancingPolicy() string {
+	if me.lbPolicy != "" {
+		return me.lbPolicy
+	}
+	if me.discovery {
+		return "round_robin"
+	}
+	return ""
+}
+
+// dialRetrying dials addr for shard no, trying again after the dial retry
+// delay when it fails, up to the configured number of attempts, see
+// WithDialRetry. With WithReconnectJitter each wait is a random share of
+// the delay (full jitter). It never waits past ctx
+func (me *Client) dialRetrying(ctx context.Context, no int, addr string) (*grpc.ClientConn, error) {
+	for attempt := 1; ; attempt++ {
+		conn, err := me.dialGrpc(ctx, addr)
+		me.metrics.ObserveDial(no, err)
+		if err == nil {
+			return conn, nil
+		}
+
+		me.printfLimited("dial "+addr, "unable to connect to pubsub service %s: %v", addr, err)
+		if attempt >= me.dialAttempts || ctx.Err() != nil {
+			return nil, err
+		}
+
+		delay := me.dialDelay
+		if me.reconnectJitter {
+			delay = jitter(delay)
+		}
+		select {
+		case <-me.clock.After(delay):
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
+
+// dialGrpc connects to service, blocking until the connection is up. The
+// dial is aborted when ctx is done and never lasts longer than the dial
+// timeout, if any. With WithNonBlockingDial it returns right away instead, the
+// first RPC connects within its own deadline
+func (me *Client) dialGrpc(ctx context.Context, service string) (*grpc.ClientConn, error) {
+	var opts []grpc.DialOption
+	if me.creds != nil {
+		opts = append(opts, grpc.WithTransportCredentials(me.creds))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	if me.keepalive != nil {
+		opts = append(opts, grpc.WithKeepaliveParams(*me.keepalive))
+	}
+	if me.windowSize > 0 {
+		opts = append(opts, grpc.WithInitialWindowSize(me.windowSize))
+	}
+	if me.connWindowSize > 0 {
+		opts = append(opts, grpc.WithInitialConnWindowSize(me.connWindowSize))
+	}
+	if me.connectBackoff != nil {
+		// grpc's default, WithConnectParams would set it to zero otherwise
+		opts = append(opts, grpc.WithConnectParams(grpc.ConnectParams{Backoff: *me.connectBackoff, MinConnectTimeout: minConnectTimeout}))
+	}
+	if me.userAgent != "" {
+		opts = append(opts, grpc.WithUserAgent(me.userAgent))
+	}
+	if policy := me.balancingPolicy(); policy != "" {
+		opts = append(opts, grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig":[{%q:{}}]}`, policy)))
+	}
+	if len(me.interceptors) > 0 {
+		opts = append(opts, grpc.WithChainUnaryInterceptor(me.interceptors...))
+	}
+	if !me.nonBlocking {
+		// Enabling WithBlock tells the client to not give up trying to find a server
+		opts = append(opts, grpc.WithBlock())
+	}
+	// appended last so they win over the defaults above
+	opts = append(opts, me.dialOptions...)
+	if me.nonBlocking || me.dialTimeout <= 0 {
+		return grpc.DialContext(ctx, service, opts...)
+	}
+
+	// However, we're still setting a timeout so that if the server takes too long, we still give up
+	ctx, cancel := context.WithTimeout(ctx, me.dialTimeout)
+	defer cancel()
+	return grpc.DialContext(ctx, service, opts...)
+}
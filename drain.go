@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// watchDrain watches the connection of shard no, s.conn, for the server
+// draining it: after a GOAWAY (e.g. the realtime pod shutting down
+// gracefully) a ready connection goes IDLE or SHUTDOWN. The shard is then
+// dialed again in the background, like a recycled connection, so the
+// replacement pod is picked up without failing the next sends. Must be
+// called with the shard lock held
+func (me *Client) watchDrain(no int, s *shard) {
+	conn := s.conn
+	if conn == nil {
+		return
+	}
+
+	go func() {
+		ready := false
+		state := conn.GetState()
+		for {
+			switch state {
+			case connectivity.Ready:
+				ready = true
+			case connectivity.Idle, connectivity.Shutdown:
+				if ready {
+					me.redialDrained(no, s, conn)
+					return
+				}
+			}
+			if state == connectivity.Shutdown {
+				return
+			}
+
+			if !conn.WaitForStateChange(context.Background(), state) {
+				return
+			}
+			state = conn.GetState()
+		}
+	}()
+}
+
+// redialDrained replaces conn, drained by the server, if shard no still
+// uses it. Connections the client closed itself are ignored
+func (me *Client) redialDrained(no int, s *shard, conn *grpc.ClientConn) {
+	if atomic.LoadInt32(&me.closed) == 1 {
+		return
+	}
+
+	s.Lock()
+	current := s.conn == conn && !s.removed
+	s.Unlock()
+	if !current {
+		return
+	}
+
+	me.logger.Printf("realtime client: the connection of shard %d is drained by the server, dialing again", no)
+	me.pool.forget(conn)
+	if atomic.CompareAndSwapInt32(&s.recycling, 0, 1) {
+		go me.recycle(no, s)
+	}
+}
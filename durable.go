@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// durableRetryInterval is how long the durable buffer waits before trying
+// its messages again once a resend failed or the buffer is empty
+const durableRetryInterval = 5 * time.Second
+
+// Store is a durable FIFO buffer of messages, e.g. a bounded queue on
+// disk, see WithDurableBuffer. The client calls it from several goroutines
+type Store interface {
+	// Enqueue persists msg at the back of the buffer, it fails when the
+	// message can't be kept (e.g. the buffer is full)
+	Enqueue(msg Message) error
+
+	// Peek returns the message at the front of the buffer without removing
+	// it, ok is false when the buffer is empty
+	Peek() (msg Message, ok bool, err error)
+
+	// Dequeue removes the message at the front of the buffer, the one Peek
+	// returned
+	Dequeue() error
+}
+
+// spillable tells whether a send failed because the realtime service
+// couldn't be reached, so the message is worth keeping for later. The grpc
+// code is read through the errors wrapping it, e.g. a *RetryError
+func spillable(err error) bool {
+	return errors.Is(err, ErrDialFailed) || errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrShardRemoved) || isRetryable(err)
+}
+
+// spill saves a message whose send to accid failed with err in the durable
+// buffer. It returns nil once the message is safe, err otherwise
+func (me *Client) spill(ctx context.Context, accid string, topics []string, payload []byte, err error) error {
+	if o := sendOptionsOf(ctx); o.pinned || o.resend || !spillable(err) {
+		return err
+	}
+
+	if serr := me.store.Enqueue(Message{AccountID: accid, Topics: topics, Payload: payload}); serr != nil {
+		return fmt.Errorf("%w (and it couldn't be buffered: %v)", err, serr)
+	}
+	return nil
+}
+
+// resendBuffered publishes the messages of the durable buffer, oldest
+// first, until the client is closed. A message is removed once published,
+// or once it fails for a reason that retrying won't fix
+func (me *Client) resendBuffered() {
+	for {
+		if !me.resendNext() {
+			select {
+			case <-me.clock.After(durableRetryInterval):
+			case <-me.done:
+				return
+			}
+		}
+	}
+}
+
+// resendNext publishes the message at the front of the durable buffer. It
+// returns false when the buffer is empty or the message must wait, e.g.
+// while publishing is disabled (SetEnabled)
+func (me *Client) resendNext() bool {
+	if !me.Enabled() {
+		return false
+	}
+
+	msg, ok, err := me.store.Peek()
+	if err != nil {
+		me.printfLimited("durable", "realtime client: unable to read the durable buffer: %v", err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+
+	ctx := context.WithValue(context.Background(), sendOptionsKey{}, &sendOptions{resend: true})
+	out, err := me.publish(ctx, msg.AccountID, msg.Topics, msg.Payload)
+	if err != nil && (spillable(err) || errors.Is(err, ErrClosed)) {
+		return false
+	}
+	if err == nil && out.shard < 0 && !me.Enabled() {
+		// disabled meanwhile, nothing was sent
+		return false
+	}
+	if err != nil {
+		me.logger.Printf("realtime client: dropping a buffered message of account %s: %v", msg.AccountID, err)
+	}
+
+	if err := me.store.Dequeue(); err != nil {
+		me.printfLimited("durable", "realtime client: unable to remove a message from the durable buffer: %v", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	client "github.com/subiz/realtime-client"
+	"github.com/subiz/realtime-client/clienttest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// memStore is a client.Store in memory
+type memStore struct {
+	mu   sync.Mutex
+	msgs []client.Message
+}
+
+func (s *memStore) Enqueue(msg client.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.msgs = append(s.msgs, msg)
+	return nil
+}
+
+func (s *memStore) Peek() (client.Message, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.msgs) == 0 {
+		return client.Message{}, false, nil
+	}
+	return s.msgs[0], true, nil
+}
+
+func (s *memStore) Dequeue() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.msgs) == 0 {
+		return errors.New("memStore: empty")
+	}
+	s.msgs = s.msgs[1:]
+	return nil
+}
+
+func (s *memStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.msgs)
+}
+
+// tickClock is a real clock whose waits of a second or more, such as the
+// retry interval of the durable buffer, only end on tick
+type tickClock struct{ ticks chan time.Time }
+
+func (c tickClock) Now() time.Time { return time.Now() }
+
+func (c tickClock) After(d time.Duration) <-chan time.Time {
+	if d < time.Second {
+		return time.After(d)
+	}
+	return c.ticks
+}
+
+// tick ends the current long wait, it returns once a waiter got it
+func (c tickClock) tick() { c.ticks <- time.Now() }
+
+// waitFor fails the test unless cond becomes true within a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestDurableBufferKeepsRetriedUnavailable(t *testing.T) {
+	store, clock := &memStore{}, tickClock{ticks: make(chan time.Time)}
+	c, srv := clienttest.NewInMemory(client.WithDurableBuffer(store), client.WithRetry(2, time.Millisecond), client.WithClock(clock))
+	defer srv.Close()
+	defer c.Close(context.Background())
+
+	srv.SetError(status.Error(codes.Unavailable, "overloaded"))
+	if err := c.Send("acc1", []string{"conversation"}, []byte(`{"id":1}`)); err != nil {
+		t.Fatalf("Send returned %v, want the message buffered", err)
+	}
+	if n := store.len(); n != 1 {
+		t.Fatalf("%d messages buffered, want 1", n)
+	}
+
+	srv.SetError(nil)
+	clock.tick()
+	waitFor(t, "the buffered message to be published", func() bool { return len(srv.Published()) == 1 })
+	waitFor(t, "the buffer to be empty", func() bool { return store.len() == 0 })
+}
+
+func TestDurableBufferWaitsWhileDisabled(t *testing.T) {
+	store, clock := &memStore{}, tickClock{ticks: make(chan time.Time)}
+	store.Enqueue(client.Message{AccountID: "acc1", Topics: []string{"conversation"}, Payload: []byte(`{"id":1}`)})
+	c, srv := clienttest.NewInMemory(client.WithDurableBuffer(store), client.WithDisabled(true), client.WithClock(clock))
+	defer srv.Close()
+	defer c.Close(context.Background())
+
+	// the second tick is only taken once the pass after the first is over
+	clock.tick()
+	clock.tick()
+	if n := store.len(); n != 1 {
+		t.Fatalf("%d messages buffered while disabled, want 1", n)
+	}
+	if n := len(srv.Published()); n != 0 {
+		t.Fatalf("%d messages published while disabled, want 0", n)
+	}
+
+	c.SetEnabled(true)
+	clock.tick()
+	waitFor(t, "the buffered message to be published", func() bool { return len(srv.Published()) == 1 })
+	waitFor(t, "the buffer to be empty", func() bool { return store.len() == 0 })
+}
+
+func TestDurableBufferResentOnceByClones(t *testing.T) {
+	const n = 200
+	store, clock := &memStore{}, tickClock{ticks: make(chan time.Time)}
+	for i := 0; i < n; i++ {
+		store.Enqueue(client.Message{AccountID: "acc1", Topics: []string{"conversation"}, Payload: []byte(strconv.Itoa(i))})
+	}
+	c, srv := clienttest.NewInMemory(client.WithDurableBuffer(store), client.WithClock(clock))
+	defer srv.Close()
+	defer c.Close(context.Background())
+	clone := c.Clone()
+	defer clone.Close(context.Background())
+
+	waitFor(t, "the buffer to be empty", func() bool { return store.len() == 0 })
+	// let a second resend loop, if any, go through what it peeked
+	time.Sleep(50 * time.Millisecond)
+	published := srv.Published()
+	if len(published) != n {
+		t.Fatalf("%d messages published, want %d", len(published), n)
+	}
+	for i, p := range published {
+		if string(p.Payload) != strconv.Itoa(i) {
+			t.Fatalf("message %d is %q, want %d: sent twice or lost", i, p.Payload, i)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrDialFailed matches (with errors.Is) every *DialError
+var ErrDialFailed = errors.New("realtime client: dial failed")
+
+// ErrShardUnavailable matches the dial errors of a shard whose address
+// doesn't resolve at all, e.g. maxNodes is larger than the number of pods
+// of the StatefulSet: every account routed to it fails until maxNodes is
+// fixed, see WithRemapMissingShards
+var ErrShardUnavailable = errors.New("realtime client: shard index exceeds the available pods")
+
+// DialError is returned when the client could not connect to a shard
+type DialError struct {
+	Shard int
+	Addr  string
+	Err   error
+}
+
+func (e *DialError) Error() string {
+	return fmt.Sprintf("realtime client: unable to connect to shard %d (%s): %v", e.Shard, e.Addr, e.Err)
+}
+
+func (e *DialError) Unwrap() error { return e.Err }
+
+// Is makes errors.Is(err, ErrDialFailed) report true
+func (e *DialError) Is(target error) bool { return target == ErrDialFailed }
+
+// ErrLocalReject matches (with errors.Is) the errors of sends the client
+// rejected before sending anything: ErrClosed, ErrNilPayload,
+// ErrInvalidTopic, ErrTooManyTopics, ErrNoTopics, ErrInvalidAccount,
+// ErrPayloadTooLarge, ErrRateLimited, ErrCircuitOpen, ErrShardRemoved and
+// ErrQueueFull, as well as routing errors (e.g. a shard func out of range)
+// and payloads failing to marshal. The realtime service never saw those
+// messages, they are safe to retry
+var ErrLocalReject = errors.New("realtime client: rejected before sending")
+
+// ErrRPC matches (with errors.Is) the errors returned by the Publish RPC
+// itself. The message may have reached the realtime service, retrying it
+// may deliver it twice. Errors matching neither ErrRPC nor ErrLocalReject
+// (e.g. dial errors) also mean the message wasn't sent
+var ErrRPC = errors.New("realtime client: publish rpc failed")
+
+// localReject is the type of the errors matching ErrLocalReject
+type localReject struct {
+	msg string
+	err error // wrapped by localRejectf's %w, if any
+}
+
+func newLocalReject(msg string) error { return &localReject{msg: msg} }
+
+// localRejectf formats an error matching ErrLocalReject, along with the
+// error wrapped by %w
+func localRejectf(format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	return &localReject{msg: err.Error(), err: errors.Unwrap(err)}
+}
+
+func (e *localReject) Error() string { return e.msg }
+
+func (e *localReject) Unwrap() error { return e.err }
+
+// Is makes errors.Is(err, ErrLocalReject) report true
+func (e *localReject) Is(target error) bool { return target == ErrLocalReject }
+
+// RPCError is the type of the errors of the Publish RPC, they match ErrRPC.
+// It exposes the grpc status of the error, so callers can tell e.g.
+// ResourceExhausted from InvalidArgument with errors.As instead of the
+// status package, which still works on it too
+type RPCError struct {
+	Err error // as returned by grpc
+}
+
+func (e *RPCError) Error() string { return e.Err.Error() }
+
+func (e *RPCError) Unwrap() error { return e.Err }
+
+// Is makes errors.Is(err, ErrRPC) report true
+func (e *RPCError) Is(target error) bool { return target == ErrRPC }
+
+// Code returns the grpc status code of the error, codes.Unknown if it has
+// no status
+func (e *RPCError) Code() codes.Code { return status.Code(e.Err) }
+
+// Message returns the message of the grpc status of the error
+func (e *RPCError) Message() string { return status.Convert(e.Err).Message() }
+
+// Details returns the details attached to the grpc status of the error
+func (e *RPCError) Details() []interface{} { return status.Convert(e.Err).Details() }
+
+// GRPCStatus returns the status of the wrapped error, see status.FromError
+func (e *RPCError) GRPCStatus() *status.Status {
+	s, _ := status.FromError(e.Err)
+	return s
+}
+
+// codeOf returns the grpc status code of err, looking through the errors
+// wrapping it (e.g. a *RetryError), which status.Code doesn't do
+func codeOf(err error) codes.Code {
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		if s := se.GRPCStatus(); s != nil {
+			return s.Code()
+		}
+	}
+	return status.Code(err)
+}
+
+// MultiError is returned by SendBatch and SendMulti when some of their
+// publishes fail, so callers can retry only those. Errors maps each failed
+// part, the message index (as a decimal string) or the account ID, to its
+// error. With WithMirrorRouting, a send whose both publishes failed returns
+// one keyed by "primary" and "mirror". errors.Is and errors.As match any of
+// the errors
+type MultiError struct {
+	Errors map[string]error
+}
+
+func (e *MultiError) Error() string {
+	failed := e.Failed()
+	msgs := make([]string, 0, len(failed))
+	for _, key := range failed {
+		msgs = append(msgs, key+": "+e.Errors[key].Error())
+	}
+	return fmt.Sprintf("%d failed: %s", len(failed), strings.Join(msgs, "; "))
+}
+
+// Failed returns the keys of the failed parts, sorted
+func (e *MultiError) Failed() []string {
+	keys := make([]string, 0, len(e.Errors))
+	for key := range e.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Unwrap returns the errors of all the failed parts, in the order of Failed
+func (e *MultiError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, key := range e.Failed() {
+		errs = append(errs, e.Errors[key])
+	}
+	return errs
+}
+
+// Is reports whether any of the errors matches target
+func (e *MultiError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error, in the order of Failed, that matches target
+func (e *MultiError) As(target interface{}) bool {
+	for _, err := range e.Unwrap() {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinErrors combines errs into a single error, it returns nil when errs is
+// empty
+func joinErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	if len(errs) == 1 {
+		return errs[0]
+	}
+
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+
+	"google.golang.org/grpc/connectivity"
+)
+
+// connEventsBuffer is the capacity of the ConnectionEvents channel
+const connEventsBuffer = 64
+
+// ConnState is the state a shard connection moved to, see ConnEvent
+type ConnState int
+
+const (
+	ConnDialing      ConnState = iota // a dial started or grpc is reconnecting
+	ConnConnected                     // the connection is READY
+	ConnDisconnected                  // the connection is idle or closed
+	ConnFailed                        // the dial or the connection failed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnDialing:
+		return "dialing"
+	case ConnConnected:
+		return "connected"
+	case ConnDisconnected:
+		return "disconnected"
+	case ConnFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// ConnEvent tells that the connection of a shard changed state
+type ConnEvent struct {
+	Shard int
+	State ConnState
+}
+
+// ConnectionEvents returns a channel receiving an event every time a shard
+// connection changes state. Connections are only watched once it has been
+// called. Events are dropped rather than blocking the client when the
+// channel is full, so it must be drained promptly. Close closes the channel
+func (me *Client) ConnectionEvents() <-chan ConnEvent {
+	me.eventsOnce.Do(func() {
+		me.eventsMu.Lock()
+		me.events = make(chan ConnEvent, connEventsBuffer)
+		if me.eventsClosed {
+			close(me.events)
+		}
+		me.eventsMu.Unlock()
+		atomic.StoreInt32(&me.eventsOn, 1)
+
+		for no, s := range me.shardList() {
+			s.Lock()
+			me.watchConn(no, s)
+			s.Unlock()
+		}
+	})
+	return me.events
+}
+
+// emit sends an event if ConnectionEvents has been called, without ever
+// blocking
+func (me *Client) emit(no int, state ConnState) {
+	if atomic.LoadInt32(&me.eventsOn) == 0 {
+		return
+	}
+
+	me.eventsMu.RLock()
+	defer me.eventsMu.RUnlock()
+	if me.eventsClosed {
+		return
+	}
+
+	select {
+	case me.events <- ConnEvent{Shard: no, State: state}:
+	default:
+	}
+}
+
+// watchConn emits the state changes of the connection of shard s until it
+// is shut down. The caller must hold the shard lock
+func (me *Client) watchConn(no int, s *shard) {
+	conn := s.conn
+	if atomic.LoadInt32(&me.eventsOn) == 0 || conn == nil || s.watched == conn {
+		return
+	}
+	s.watched = conn
+
+	go func() {
+		state := conn.GetState()
+		for {
+			me.emit(no, connState(state))
+			if state == connectivity.Shutdown {
+				return
+			}
+
+			if !conn.WaitForStateChange(context.Background(), state) {
+				return
+			}
+			state = conn.GetState()
+		}
+	}()
+}
+
+// closeEvents closes the ConnectionEvents channel
+func (me *Client) closeEvents() {
+	me.eventsMu.Lock()
+	defer me.eventsMu.Unlock()
+	if me.eventsClosed {
+		return
+	}
+
+	me.eventsClosed = true
+	if me.events != nil {
+		close(me.events)
+	}
+}
+
+// connState maps a grpc connectivity state to the state of an event
+func connState(state connectivity.State) ConnState {
+	switch state {
+	case connectivity.Connecting:
+		return ConnDialing
+	case connectivity.Ready:
+		return ConnConnected
+	case connectivity.TransientFailure:
+		return ConnFailed
+	}
+	return ConnDisconnected
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/subiz/header"
+)
+
+// connectShard returns a ready client for shard no. When the shard can't be
+// reached and WithFallback is set, the next shards are tried in order, up
+// to the configured number of hops. A shard marked unhealthy is fallen back
+// from right away, but still used when no other shard is reachable. The
+// returned index is the shard that is actually used
+func (me *Client) connectShard(ctx context.Context, no int) (int, header.PubsubClient, error) {
+	if me.fallback && me.shardUnhealthy(no) {
+		if next, client, err := me.fallbackFrom(ctx, no, fmt.Errorf("shard %d is marked unhealthy", no)); err == nil {
+			return next, client, nil
+		}
+	}
+
+	client, err := me.tryShard(ctx, no)
+	if err == nil || !me.fallback || !isUnreachable(err) {
+		return no, client, err
+	}
+	return me.fallbackFrom(ctx, no, err)
+}
+
+// fallbackFrom tries the shards after no, skipping the ones marked
+// unhealthy. It returns cause when none of them is reachable
+func (me *Client) fallbackFrom(ctx context.Context, no int, cause error) (int, header.PubsubClient, error) {
+	shards := len(me.primaryShards())
+	for hop := 1; hop <= me.fallbackHops && hop < shards; hop++ {
+		next := (no + hop) % shards
+		if me.shardUnhealthy(next) {
+			continue
+		}
+
+		client, err := me.tryShard(ctx, next)
+		if err == nil {
+			me.printfLimited("fallback "+strconv.Itoa(no), "shard %d is unreachable, falling back to shard %d: %v", no, next, cause)
+			return next, client, nil
+		}
+
+		if !isUnreachable(err) {
+			break
+		}
+	}
+	return no, nil, cause
+}
+
+// remapShard routes accid, whose shard no has no pod (ErrShardUnavailable),
+// over the shards below no instead, the ones left when the StatefulSet has
+// fewer pods than maxNodes. The account lands on the same shard every
+// time. It returns cause when no lower shard is reachable
+func (me *Client) remapShard(ctx context.Context, accid string, no int, cause error) (int, header.PubsubClient, error) {
+	for n := no; n > 0; {
+		next := me.shardFunc(accid, n)
+		if next < 0 || next >= n {
+			// e.g. WithWeights, which ignores the node count
+			break
+		}
+
+		client, err := me.tryShard(ctx, next)
+		if err == nil {
+			me.printfLimited("remap "+strconv.Itoa(no), "shard %d has no pod, remapping its accounts to the shards below", no)
+			return next, client, nil
+		}
+		if !errors.Is(err, ErrShardUnavailable) {
+			return next, nil, err
+		}
+		n = next
+	}
+	return no, nil, cause
+}
+
+// tryShard connects to shard no unless its circuit breaker is open
+func (me *Client) tryShard(ctx context.Context, no int) (header.PubsubClient, error) {
+	if err := me.allowShard(no); err != nil {
+		return nil, err
+	}
+
+	client, err := me.shardClient(ctx, no)
+	if err != nil {
+		me.recordShard(ctx, no, err)
+	}
+	return client, err
+}
+
+// isUnreachable tells whether err means the shard itself is down, as
+// opposed to the client being closed or the caller giving up
+func isUnreachable(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) || (errors.Is(err, ErrDialFailed) && !errors.Is(err, context.Canceled))
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"google.golang.org/grpc/connectivity"
+)
+
+// Ready returns a channel closed once the client is connected to at least
+// one shard, or to every shard with WithReadyOnAllShards. Shards are dialed
+// lazily by sends, use Prime to dial them all up front. The channel stays
+// closed even if connections are lost later, use HealthCheck to check the
+// current state
+func (me *Client) Ready() <-chan struct{} {
+	return me.ready
+}
+
+// checkReady closes the Ready channel once its condition is met, it is
+// called after every successful dial
+func (me *Client) checkReady() {
+	if me.readyAll {
+		for _, s := range me.primaryShards() {
+			if s.load() == nil {
+				return
+			}
+		}
+	}
+	me.readyOnce.Do(func() { close(me.ready) })
+}
+
+// HealthCheck connects to every shard (reusing live connections) and reports
+// the result per shard index, a nil error means the shard is READY. Shards
+// that haven't answered when ctx is done report ctx's error
+func (me *Client) HealthCheck(ctx context.Context) map[int]error {
+	type result struct {
+		no  int
+		err error
+	}
+
+	shards := me.primaryShards()
+	results := make(chan result, len(shards))
+	for no := range shards {
+		go func(no int) { results <- result{no: no, err: me.checkShard(ctx, no)} }(no)
+	}
+
+	out := make(map[int]error, len(shards))
+	for len(out) < len(shards) {
+		select {
+		case r := <-results:
+			out[r.no] = r.err
+		case <-ctx.Done():
+			for no := range shards {
+				if _, has := out[no]; !has {
+					out[no] = ctx.Err()
+				}
+			}
+		}
+	}
+	return out
+}
+
+// checkShard waits until shard no has a READY connection
+func (me *Client) checkShard(ctx context.Context, no int) error {
+	if _, err := me.shardClient(ctx, no); err != nil {
+		return err
+	}
+
+	s, err := me.shardAt(no)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	conn := s.conn
+	s.Unlock()
+	if conn == nil {
+		return ErrClosed
+	}
+
+	for state := conn.GetState(); state != connectivity.Ready; state = conn.GetState() {
+		if !conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("realtime client: shard %d (%s) is %s: %w", no, conn.Target(), state, ctx.Err())
+		}
+	}
+	return nil
+}
+
+// Prime connects to every shard up front so the first sends don't pay for
+// the dials. It returns the dial errors of all the shards that failed, or
+// ctx's error if ctx is done before every shard answered, which also aborts
+// the pending dials
+func (me *Client) Prime(ctx context.Context) error {
+	shards := make([]int, len(me.primaryShards()))
+	for no := range shards {
+		shards[no] = no
+	}
+	return me.primeShards(ctx, shards)
+}
+
+// PrimeShards is like Prime but only connects to the given shards, e.g. the
+// few an instance publishes to. Nothing is dialed if an index is out of
+// range, the error lists every such index
+func (me *Client) PrimeShards(ctx context.Context, shards ...int) error {
+	n := len(me.primaryShards())
+	var errs []error
+	for _, no := range shards {
+		if no < 0 || no >= n {
+			errs = append(errs, fmt.Errorf("realtime client: shard %d out of range [0, %d)", no, n))
+		}
+	}
+	if len(errs) > 0 {
+		return joinErrors(errs)
+	}
+	return me.primeShards(ctx, shards)
+}
+
+// primeShards connects to shards concurrently, see Prime
+func (me *Client) primeShards(ctx context.Context, shards []int) error {
+	errc := make(chan error, len(shards))
+	for _, no := range shards {
+		go func(no int) {
+			_, err := me.shardClient(ctx, no)
+			errc <- err
+		}(no)
+	}
+
+	var errs []error
+	for range shards {
+		select {
+		case err := <-errc:
+			if err != nil {
+				errs = append(errs, err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return joinErrors(errs)
+}
+
+// MarkShardUnhealthy flags a shard as known bad, e.g. during an incident.
+// It is a hint: publishes routed to the shard are still attempted, with
+// PublishEvent.Unhealthy set, unless WithFallback is set, which then prefers
+// the next healthy shard
+func (me *Client) MarkShardUnhealthy(index int) error {
+	return me.markShard(index, true)
+}
+
+// MarkShardHealthy clears the flag set by MarkShardUnhealthy
+func (me *Client) MarkShardHealthy(index int) error {
+	return me.markShard(index, false)
+}
+
+func (me *Client) markShard(index int, unhealthy bool) error {
+	s, err := me.shardAt(index)
+	if err != nil {
+		return err
+	}
+
+	var v int32
+	if unhealthy {
+		v = 1
+	}
+	if atomic.SwapInt32(&s.unhealthy, v) != v {
+		me.logger.Printf("realtime client: shard %d marked unhealthy: %v", index, unhealthy)
+	}
+	return nil
+}
+
+// shardUnhealthy tells whether shard no is marked unhealthy
+func (me *Client) shardUnhealthy(no int) bool {
+	s, err := me.shardAt(no)
+	return err == nil && atomic.LoadInt32(&s.unhealthy) == 1
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// PublishInfo describes a publish, see PublishHook
+type PublishInfo struct {
+	AccountID   string
+	Shard       int
+	Topics      int // number of topics
+	PayloadSize int // in bytes
+}
+
+// PublishHook is called before every publish. It returns the context the
+// publish must use (e.g. carrying a tracing span) and a function the client
+// calls with the result once the publish is over. The otelclient subpackage
+// uses it for OpenTelemetry tracing
+type PublishHook func(ctx context.Context, info PublishInfo) (context.Context, func(error))
+
+// PublishEvent describes a publish attempt, see WithObserver
+type PublishEvent struct {
+	AccountID   string
+	Shard       int
+	Topics      int // number of topics
+	PayloadSize int // in bytes
+	Duration    time.Duration
+	Attempt     int   // 1 for the first try, then increasing with retries
+	Err         error // nil if the attempt succeeded
+	Unhealthy   bool  // the shard is marked unhealthy, see MarkShardUnhealthy
+}
+
+// observe hands ev to the observer. A panicking observer is logged and
+// otherwise ignored
+func (me *Client) observe(ev PublishEvent) {
+	if me.observer == nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			me.logger.Printf("realtime client: publish observer panicked: %v", r)
+		}
+	}()
+	me.observer(ev)
+}
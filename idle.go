@@ -0,0 +1,88 @@
+package client
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// closeIdle closes the shard connections idle for longer than the max idle
+// time, every half of it, until the client is closed
+func (me *Client) closeIdle() {
+	for {
+		select {
+		case <-me.clock.After(me.maxIdleTime / 2):
+		case <-me.done:
+			return
+		}
+
+		now := me.clock.Now()
+		for no, s := range me.shardList() {
+			if last, ok := me.lastUse(s); ok && now.Sub(last) >= me.maxIdleTime {
+				me.closeShardConn(no, s, "idle since "+last.String())
+			}
+		}
+	}
+}
+
+// evictLRU closes the connections of the least recently used shards, other
+// than shard keep, while more than the WithMaxConnections limit are open
+func (me *Client) evictLRU(keep int) {
+	if me.maxConns <= 0 {
+		return
+	}
+
+	shards := me.shardList()
+	for {
+		open, lru := 0, -1
+		var lruAt time.Time
+		for no, s := range shards {
+			last, ok := me.lastUse(s)
+			if !ok {
+				continue
+			}
+			open++
+			if no != keep && (lru < 0 || last.Before(lruAt)) {
+				lru, lruAt = no, last
+			}
+		}
+
+		if open <= me.maxConns || lru < 0 {
+			return
+		}
+		me.closeShardConn(lru, shards[lru], "least recently used")
+	}
+}
+
+// lastUse returns when the connection of s last carried a publish, or when
+// it was dialed if it never did. ok is false if s isn't connected
+func (me *Client) lastUse(s *shard) (last time.Time, ok bool) {
+	s.Lock()
+	defer s.Unlock()
+	if s.conn == nil {
+		return time.Time{}, false
+	}
+
+	last = s.since
+	if used := atomic.LoadInt64(&s.lastUsed); used > last.UnixNano() {
+		last = time.Unix(0, used)
+	}
+	return last, true
+}
+
+// closeShardConn drops the connection of shard no, the next send dials it
+// again. The connection is released after recycleGrace so a publish that
+// just picked it up still completes
+func (me *Client) closeShardConn(no int, s *shard, why string) {
+	s.Lock()
+	conn := s.conn
+	if conn == nil {
+		s.Unlock()
+		return
+	}
+	s.drop()
+	me.metrics.SetConnected(no, false)
+	s.Unlock()
+
+	me.logger.Printf("realtime client: closing the connection of shard %d, %s", no, why)
+	me.releaseLater(conn)
+}
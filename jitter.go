@@ -0,0 +1,25 @@
+package client
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// jitterRand is seeded per process, so clients restarted together don't
+// draw the same delays
+var jitterRand = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// jitter returns a random duration in [0, d]
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+
+	jitterRand.Lock()
+	defer jitterRand.Unlock()
+	return time.Duration(jitterRand.Int63n(int64(d) + 1))
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Logger is the minimal logging interface used by the client. *log.Logger
+// satisfies it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(format string, v ...interface{}) {}
+
+// logDedupWindow is how long identical log lines of the same key are
+// collapsed, see printfLimited
+const logDedupWindow = 10 * time.Second
+
+// logLimiter remembers the last line logged for each key, see printfLimited
+type logLimiter struct {
+	sync.Mutex
+	last map[string]*loggedLine
+}
+
+type loggedLine struct {
+	msg        string
+	at         time.Time
+	suppressed int // identical lines dropped since at
+}
+
+func newLogLimiter() *logLimiter {
+	return &logLimiter{last: map[string]*loggedLine{}}
+}
+
+// printfLimited logs like Printf, but drops the lines identical to the last
+// one of key logged less than logDedupWindow ago, e.g. the dial error of a
+// shard that is down, repeated by every send. How many were dropped is
+// logged with the next line of key that goes through
+func (me *Client) printfLimited(key, format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	now := me.clock.Now()
+
+	me.logLimit.Lock()
+	last := me.logLimit.last[key]
+	if last != nil && last.msg == msg && now.Sub(last.at) < logDedupWindow {
+		last.suppressed++
+		me.logLimit.Unlock()
+		return
+	}
+	me.logLimit.last[key] = &loggedLine{msg: msg, at: now}
+	me.logLimit.Unlock()
+
+	if last != nil && last.suppressed > 0 {
+		me.logger.Printf("realtime client: suppressed %d occurrences of %q in %v", last.suppressed, last.msg, now.Sub(last.at).Round(time.Second))
+	}
+	me.logger.Printf("%s", msg)
+}
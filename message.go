@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Message is a single payload to publish, see Publish and SendBatch
+type Message struct {
+	AccountID string // ignored by SendBatch, which takes the account apart
+	Topics    []string
+	Payload   []byte
+
+	// TTL is sent under TTLHeader when at least a millisecond, see
+	// SendWithTTL
+	TTL time.Duration
+
+	// Headers are sent as outgoing metadata, keys are lowercased
+	Headers map[string]string
+
+	// Attributes are sent as outgoing metadata too, each key prefixed with
+	// AttributeHeaderPrefix, see SendWithAttributes
+	Attributes map[string]string
+
+	// IdempotencyKey is sent under IdempotencyKeyHeader when not empty, see
+	// SendWithKey
+	IdempotencyKey string
+
+	// OrderingKey orders the message with the account's messages of the
+	// same key only, and is sent under OrderingKeyHeader, see
+	// WithOrderingKey. It doesn't change the shard
+	OrderingKey string
+}
+
+// AttributeHeaderPrefix prefixes the metadata keys carrying the attributes
+// of a message, see SendWithAttributes
+const AttributeHeaderPrefix = "x-attr-"
+
+// SendWithAttributes is like Send but attaches attrs (e.g. event type,
+// schema version, source) to the message, so consumers can filter and route
+// it without decoding the payload. The publish message has no attributes
+// field: each attribute is sent as outgoing metadata, under its key
+// lowercased and prefixed with AttributeHeaderPrefix. Subscribers only see
+// them if the realtime service forwards those headers with the message
+func (me *Client) SendWithAttributes(accid string, topics []string, payload []byte, attrs map[string]string) error {
+	return me.Publish(Message{AccountID: accid, Topics: topics, Payload: payload, Attributes: attrs})
+}
+
+// Publish delivers msg to the realtime service of its account. It behaves
+// like Send, which is Publish with only the account, topics and payload
+// set
+func (me *Client) Publish(msg Message) error {
+	return me.PublishContext(context.Background(), msg)
+}
+
+// PublishContext is like Publish but uses ctx for the publish RPC
+func (me *Client) PublishContext(ctx context.Context, msg Message) error {
+	ctx = msg.context(ctx)
+	if msg.OrderingKey != "" {
+		ctx = withOrderingKey(ctx, msg.OrderingKey)
+	}
+	_, err := me.publish(ctx, msg.AccountID, msg.Topics, msg.Payload)
+	return err
+}
+
+// context adds the metadata of msg to ctx
+func (msg Message) context(ctx context.Context) context.Context {
+	var md []string
+	for k, v := range msg.Headers {
+		md = append(md, k, v)
+	}
+	for k, v := range msg.Attributes {
+		md = append(md, AttributeHeaderPrefix+k, v)
+	}
+	if ms := msg.TTL.Milliseconds(); ms > 0 {
+		md = append(md, TTLHeader, strconv.FormatInt(ms, 10))
+	}
+	if msg.IdempotencyKey != "" {
+		md = append(md, IdempotencyKeyHeader, msg.IdempotencyKey)
+	}
+	if msg.OrderingKey != "" {
+		md = append(md, OrderingKeyHeader, msg.OrderingKey)
+	}
+
+	if len(md) == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, md...)
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/metadata"
+)
+
+// outgoingContext adds the client's static metadata to ctx, keeping any
+// outgoing metadata the caller already put there
+func (me *Client) outgoingContext(ctx context.Context) context.Context {
+	if len(me.metadata) == 0 {
+		return ctx
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return metadata.NewOutgoingContext(ctx, metadata.Join(me.metadata, md))
+}
+
+// IdempotencyKeyHeader is the metadata key carrying the idempotency key of a
+// publish
+const IdempotencyKeyHeader = "x-idempotency-key"
+
+// SendWithKey is like Send but tags the publish with an idempotency key, so
+// a realtime service that dedupes on IdempotencyKeyHeader delivers it once
+// even if it is retried. A random UUID is used when key is empty. Dedup only
+// happens if the server supports it, the client just sends the key
+func (me *Client) SendWithKey(accid string, topics []string, payload []byte, key string) error {
+	if key == "" {
+		key = newUUID()
+	}
+	return me.Publish(Message{AccountID: accid, Topics: topics, Payload: payload, IdempotencyKey: key})
+}
+
+// AccountIDHeader is the default metadata key carrying the account ID of a
+// publish, see WithAccountIDHeader
+const AccountIDHeader = "x-account-id"
+
+type accountIDKey struct{}
+
+// AccountIDFromContext returns the account ID of the publish ctx was made
+// for. Client interceptors can use it to see the account without looking
+// into the request
+func AccountIDFromContext(ctx context.Context) (string, bool) {
+	accid, ok := ctx.Value(accountIDKey{}).(string)
+	return accid, ok
+}
+
+// withAccountID puts the account ID of a publish into ctx, both as a value
+// and as outgoing metadata unless the header is turned off
+func (me *Client) withAccountID(ctx context.Context, accid string) context.Context {
+	ctx = context.WithValue(ctx, accountIDKey{}, accid)
+	if me.accountIDHeader == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, me.accountIDHeader, accid)
+}
+
+// ChecksumHeader is the metadata key carrying the CRC-32 (IEEE) of the
+// payload, in hex, see WithPayloadChecksum
+const ChecksumHeader = "x-payload-crc32"
+
+// withChecksum adds the payload's checksum to ctx when WithPayloadChecksum
+// is set
+func (me *Client) withChecksum(ctx context.Context, payload []byte) context.Context {
+	if !me.checksum {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, ChecksumHeader, fmt.Sprintf("%08x", crc32.ChecksumIEEE(payload)))
+}
+
+// ContentTypeHeader is the metadata key carrying the payload's media type.
+// content-type itself is reserved by grpc
+const ContentTypeHeader = "x-content-type"
+
+// SendJSON is like Send but publishes v encoded as JSON, tagged with
+// ContentTypeHeader set to application/json. It returns the marshal error
+// without publishing anything if v can't be encoded
+func (me *Client) SendJSON(accid string, topics []string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return localRejectf("realtime client: marshal payload: %w", err)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), ContentTypeHeader, "application/json")
+	_, err = me.publish(ctx, accid, topics, payload)
+	return err
+}
+
+// TTLHeader is the metadata key carrying the time to live of a publish, in
+// milliseconds
+const TTLHeader = "x-message-ttl"
+
+// SendWithTTL is like Send but tells the realtime service the message is
+// only meaningful for ttl, e.g. a typing indicator that shouldn't reach
+// clients reconnecting later. The publish message has no expiry field so the
+// TTL is sent under TTLHeader: the server must honor that header for
+// messages to actually expire, others deliver them as usual. A ttl under a
+// millisecond sends no TTL
+func (me *Client) SendWithTTL(accid string, topics []string, payload []byte, ttl time.Duration) error {
+	return me.Publish(Message{AccountID: accid, Topics: topics, Payload: payload, TTL: ttl})
+}
+
+// TombstoneHeader is the metadata key marking a publish as a tombstone, see
+// SendTombstone
+const TombstoneHeader = "x-tombstone"
+
+// SendTombstone publishes a tombstone to topics of accid, telling consumers
+// to clear what they hold for those topics. On the wire it is a publish
+// with an empty (non nil) payload and TombstoneHeader set to "1", so it
+// can't be mistaken for an empty message or a payload lost to a
+// serialization bug, which Send rejects with ErrNilPayload
+func (me *Client) SendTombstone(accid string, topics []string) error {
+	ctx := metadata.AppendToOutgoingContext(context.Background(), TombstoneHeader, "1")
+	_, err := me.publish(ctx, accid, topics, []byte{})
+	return err
+}
+
+// TypeURLHeader is the metadata key carrying the type URL of a payload
+// published with SendProto
+const TypeURLHeader = "x-type-url"
+
+// SendProto is like Send but publishes m encoded with proto.Marshal, tagged
+// with its type URL (type.googleapis.com/ followed by the full message name)
+// under TypeURLHeader and ContentTypeHeader set to application/protobuf. It
+// returns the marshal error without publishing anything if m can't be
+// encoded
+func (me *Client) SendProto(accid string, topics []string, m proto.Message) error {
+	payload, err := proto.Marshal(m)
+	if err != nil {
+		return localRejectf("realtime client: marshal payload: %w", err)
+	}
+	if payload == nil {
+		// a message with no field set encodes to nothing, it is still a message
+		payload = []byte{}
+	}
+
+	md := []string{ContentTypeHeader, "application/protobuf"}
+	if name := proto.MessageName(m); name != "" {
+		md = append(md, TypeURLHeader, "type.googleapis.com/"+name)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), md...)
+	_, err = me.publish(ctx, accid, topics, payload)
+	return err
+}
+
+// withIdempotencyKey adds a fresh idempotency key to ctx when
+// WithIdempotencyKeys is set and the caller didn't supply one. It must be
+// called once per message, before any retry, so retries share the key
+func (me *Client) withIdempotencyKey(ctx context.Context) context.Context {
+	if !me.idempotencyKeys {
+		return ctx
+	}
+
+	if md, _ := metadata.FromOutgoingContext(ctx); len(md.Get(IdempotencyKeyHeader)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, IdempotencyKeyHeader, newUUID())
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,20 @@
+package client
+
+import "time"
+
+// Metrics receives instrumentation events from the client. The promclient
+// subpackage provides a Prometheus implementation
+type Metrics interface {
+	// ObservePublish is called after every Publish RPC
+	ObservePublish(shard int, d time.Duration, err error)
+	// ObserveDial is called after every attempt to connect to a shard
+	ObserveDial(shard int, err error)
+	// SetConnected is called when a shard connection is opened or dropped
+	SetConnected(shard int, connected bool)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ObservePublish(shard int, d time.Duration, err error) {}
+func (nopMetrics) ObserveDial(shard int, err error)                     {}
+func (nopMetrics) SetConnected(shard int, connected bool)               {}
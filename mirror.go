@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	pb "github.com/subiz/header/realtime"
+)
+
+// startMirror publishes the message in the background to the shard the
+// mirror routing picks, if WithMirrorRouting is set and it isn't primary.
+// It returns nil when there is nothing to mirror, else a channel receiving
+// the mirror publish's error
+func (me *Client) startMirror(ctx context.Context, primary int, accid string, topics []string, payload []byte) <-chan error {
+	if me.mirrorFunc == nil || me.shared {
+		return nil
+	}
+
+	no := me.mirrorFunc(accid, me.mirrorNodes)
+	if no == primary {
+		return nil
+	}
+
+	errc := make(chan error, 1)
+	if no < 0 || no >= me.mirrorNodes {
+		errc <- localRejectf("realtime client: mirror shard func returned %d for account %s, want a value in [0, %d)", no, accid, me.mirrorNodes)
+		return errc
+	}
+
+	go func() {
+		client, err := me.tryShard(ctx, no)
+		if err == nil {
+			_, err = me.callPublish(me.withIdempotencyKey(me.outgoingContext(ctx)), no, client, &pb.PublishMessage{AccountId: accid, Payload: payload, Topics: topics})
+			me.recordShard(ctx, no, err)
+		}
+		if err != nil {
+			err = fmt.Errorf("mirror shard %d: %w", no, err)
+		}
+		errc <- err
+	}()
+	return errc
+}
+
+// joinMirror combines the errors of a primary publish and of its mirror.
+// Unless both failed, the error of the one that did is returned as is
+func joinMirror(primary, mirror error) error {
+	if primary == nil {
+		return mirror
+	}
+	if mirror == nil {
+		return primary
+	}
+	return &MultiError{Errors: map[string]error{"primary": primary, "mirror": mirror}}
+}
@@ -0,0 +1,606 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"hash/crc32"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+)
+
+// ClientOption configures a Client, see NewClient
+type ClientOption func(*Client)
+
+// WithTransportCredentials makes every shard connection use creds for
+// transport security. Without it, connections are insecure
+func WithTransportCredentials(creds credentials.TransportCredentials) ClientOption {
+	return func(me *Client) { me.creds = creds }
+}
+
+// WithTLS makes every shard connection use TLS with the given config
+func WithTLS(cfg *tls.Config) ClientOption {
+	return WithTransportCredentials(credentials.NewTLS(cfg))
+}
+
+// WithDialTimeout bounds how long dialing a shard may block before giving
+// up. Defaults to 120 seconds, 0 means no bound, see WithoutDialTimeout
+func WithDialTimeout(d time.Duration) ClientOption {
+	return func(me *Client) { me.dialTimeout = d }
+}
+
+// WithoutDialTimeout removes the dial timeout while still blocking until
+// the connection is ready: a dial is only bounded by the context of the
+// send that started it, grpc's connection backoff (see WithConnectBackoff)
+// paces the attempts. Sends without a deadline and the background dials
+// of recycled connections then wait as long as the shard is down
+func WithoutDialTimeout() ClientOption {
+	return WithDialTimeout(0)
+}
+
+// WithShardFunc overrides how accounts are mapped to shards, e.g. to pin
+// some accounts to a node during a migration. The default is
+// crc32(accid) % maxNodes
+func WithShardFunc(f ShardFunc) ClientOption {
+	return func(me *Client) { me.shardFunc = f }
+}
+
+// WithLogger makes the client report internal problems (e.g. failed dials)
+// to l. By default nothing is logged
+func WithLogger(l Logger) ClientOption {
+	return func(me *Client) { me.logger = l }
+}
+
+// WithRetry retries publishes failing with Unavailable or DeadlineExceeded
+// up to maxAttempts tries in total, waiting backoff before the first retry
+// and doubling it after each one. Other errors are returned right away
+func WithRetry(maxAttempts int, backoff time.Duration) ClientOption {
+	return func(me *Client) {
+		me.retryAttempts = maxAttempts
+		me.retryBackoff = backoff
+	}
+}
+
+// WithRetryPolicy sets how publishes are retried depending on the grpc code
+// they failed with, replacing WithRetry: e.g. retry Unavailable quickly,
+// back off harder on ResourceExhausted. Codes missing from policy, such as
+// InvalidArgument, are never retried. Without it, the built-in policy
+// retries Unavailable and DeadlineExceeded as set with WithRetry
+func WithRetryPolicy(policy map[codes.Code]RetryRule) ClientOption {
+	return func(me *Client) {
+		me.retryPolicy = make(map[codes.Code]RetryRule, len(policy))
+		for code, rule := range policy {
+			me.retryPolicy[code] = rule
+		}
+	}
+}
+
+// WithDropHandler calls f, in its own goroutine, with every message
+// SendAsync discards without sending it: when the queue is full
+// (ErrQueueFull) or the client is closing (ErrClosed), including the
+// messages still queued when Close gives up waiting. Messages whose publish
+// failed aren't dropped, their error goes to the SendAsync callback
+func WithDropHandler(f func(msg Message, reason error)) ClientOption {
+	return func(me *Client) { me.dropHandler = f }
+}
+
+// WithDurableBuffer keeps the messages the client fails to deliver because
+// the realtime service can't be reached (dial errors, an open circuit,
+// Unavailable, ...) in store, the send then returns nil. A background loop
+// publishes them again, oldest first, as soon as the service answers, and
+// drops the ones that fail for good (e.g. InvalidArgument). Only the
+// account, topics and payload are kept, the metadata of the send is lost,
+// and buffered messages may arrive after newer ones. Close leaves what is
+// still buffered in store, for the next process with the same store to
+// send: delivery is at least once. Messages rejected for what they are
+// (ErrInvalidTopic, ErrPayloadTooLarge, ...) are never buffered. A store
+// must be resent by a single client: don't give it to several clients, use
+// Clone to share it
+func WithDurableBuffer(store Store) ClientOption {
+	return func(me *Client) { me.store, me.storeInherited = store, false }
+}
+
+// WithAsyncQueueSize sets how many messages SendAsync can hold before it
+// starts returning ErrQueueFull. Defaults to 1024
+func WithAsyncQueueSize(n int) ClientOption {
+	return func(me *Client) { me.asyncQueueSize = n }
+}
+
+// WithMetricsCollector reports publishes, dials and live connections to m
+func WithMetricsCollector(m Metrics) ClientOption {
+	return func(me *Client) { me.metrics = m }
+}
+
+// DefaultKeepalive pings idle connections every 30 seconds so they survive
+// load balancers and NATs that drop idle TCP connections. It is not applied
+// unless passed to WithKeepalive since the server must allow pings this
+// frequent (its keepalive EnforcementPolicy), otherwise it closes the
+// connection with "too_many_pings"
+var DefaultKeepalive = keepalive.ClientParameters{
+	Time:                30 * time.Second,
+	Timeout:             10 * time.Second,
+	PermitWithoutStream: true,
+}
+
+// WithKeepalive makes shard connections send keepalive pings with params
+func WithKeepalive(params keepalive.ClientParameters) ClientOption {
+	return func(me *Client) { me.keepalive = &params }
+}
+
+// WithConnectBackoff sets how fast grpc tries to connect again after a
+// shard connection failed: the delay starts at the base delay and grows by
+// the multiplier up to the max delay. Defaults to backoff.DefaultConfig
+func WithConnectBackoff(config backoff.Config) ClientOption {
+	return func(me *Client) { me.connectBackoff = &config }
+}
+
+// WithInitialWindowSize sets the HTTP/2 flow control window of each stream
+// of the shard connections, in bytes. It turns off grpc's dynamic window
+// sizing, the default. grpc ignores values under 64KB
+func WithInitialWindowSize(n int32) ClientOption {
+	return func(me *Client) { me.windowSize = n }
+}
+
+// WithInitialConnWindowSize is like WithInitialWindowSize for the window of
+// the whole connection, shared by all its streams
+func WithInitialConnWindowSize(n int32) ClientOption {
+	return func(me *Client) { me.connWindowSize = n }
+}
+
+// WithDialOptions adds extra grpc dial options (interceptors, message size
+// limits, service config...) to every shard connection. They are applied
+// after the client's own options so they can override them
+func WithDialOptions(opts ...grpc.DialOption) ClientOption {
+	return func(me *Client) { me.dialOptions = append(me.dialOptions, opts...) }
+}
+
+// WithMaxPayloadSize rejects payloads bigger than n bytes with
+// ErrPayloadTooLarge before they are sent. By default there is no limit
+func WithMaxPayloadSize(n int) ClientOption {
+	return func(me *Client) { me.maxPayloadSize = n }
+}
+
+// WithSizeWarnThreshold calls warn, in its own goroutine, for every payload
+// bigger than n bytes, e.g. to find the call sites publishing bloated
+// messages. Unlike WithMaxPayloadSize the message is still sent
+func WithSizeWarnThreshold(n int, warn func(accid string, size int)) ClientOption {
+	return func(me *Client) {
+		me.sizeWarn = n
+		me.sizeWarnFunc = warn
+	}
+}
+
+// WithShardLoadLog logs, every interval, how many publishes went to each
+// shard during the interval, to spot hot shards from an uneven account
+// spread. The same counters are always available from Stats
+func WithShardLoadLog(interval time.Duration) ClientOption {
+	return func(me *Client) { me.loadLogInterval = interval }
+}
+
+// WithMaxIdleTime closes the connection of a shard that carried no publish
+// for d, the next send to the shard dials it again. It keeps the number of
+// connections down when only a few accounts are active. Idle connections
+// are looked for every d/2
+func WithMaxIdleTime(d time.Duration) ClientOption {
+	return func(me *Client) { me.maxIdleTime = d }
+}
+
+// WithContextFunc derives the context of every published message with f,
+// e.g. to add an auth token, a deadline or trace metadata in one place. f
+// is called once per message, its retries reuse the context, after the
+// client added its own metadata. Sends made without a context, such as
+// Send, go through it too
+func WithContextFunc(f func(ctx context.Context, accid string) context.Context) ClientOption {
+	return func(me *Client) { me.contextFunc = f }
+}
+
+// WithMaxConnections keeps at most n shards connected: once a new shard is
+// connected past the limit, the connection of the least recently used one
+// is closed, it dials again on its next send. It bounds the file
+// descriptors of instances touching many shards with a large maxNodes, at
+// the cost of dials for shards going back and forth. An evicted connection
+// is closed a minute later so the publishes that just picked it up still
+// complete, the limit can be exceeded meanwhile under churn
+func WithMaxConnections(n int) ClientOption {
+	return func(me *Client) { me.maxConns = n }
+}
+
+// WithCallOptions adds grpc call options to every Publish RPC
+func WithCallOptions(opts ...grpc.CallOption) ClientOption {
+	return func(me *Client) { me.callOptions = append(me.callOptions, opts...) }
+}
+
+// WithGzip compresses publish requests with gzip. The realtime service must
+// have the gzip decompressor registered (importing
+// google.golang.org/grpc/encoding/gzip is enough), otherwise every publish
+// fails with Unimplemented
+func WithGzip() ClientOption {
+	return WithCallOptions(grpc.UseCompressor(gzip.Name))
+}
+
+// WithWaitForReady makes publishes to a shard whose connection is down wait
+// until it is ready again, within the publish's deadline (see
+// WithPublishTimeout), instead of failing fast with Unavailable. Use
+// WithCallWaitForReady to override it per call
+func WithWaitForReady(wait bool) ClientOption {
+	return WithCallOptions(grpc.WaitForReady(wait))
+}
+
+// WithTopicValidator makes the client check every topic with f before
+// publishing, a non nil error rejects the message with ErrInvalidTopic.
+// Empty topics are always rejected
+func WithTopicValidator(f func(topic string) error) ClientOption {
+	return func(me *Client) { me.topicValidator = f }
+}
+
+// WithAccountValidator makes the client check every account ID with f
+// before routing, a non nil error rejects the message with
+// ErrInvalidAccount. Empty account IDs are always rejected
+func WithAccountValidator(f func(accid string) error) ClientOption {
+	return func(me *Client) { me.accountValidator = f }
+}
+
+// WithHashFunc routes accounts to hash(accid) % maxNodes instead of using
+// the IEEE CRC-32 of the account ID. The realtime service must compute the
+// very same hash, otherwise accounts are published to a node that doesn't
+// own them
+func WithHashFunc(hash func(accid []byte) uint32) ClientOption {
+	return func(me *Client) { me.shardFunc = hashShard(hash) }
+}
+
+// WithCRC32Polynomial is like WithHashFunc with a CRC-32 over another
+// polynomial, e.g. crc32.Castagnoli, to match the routing of the realtime
+// service
+func WithCRC32Polynomial(poly uint32) ClientOption {
+	table := crc32.MakeTable(poly)
+	return WithHashFunc(func(accid []byte) uint32 { return crc32.Checksum(accid, table) })
+}
+
+// WithConsistentHashing routes accounts with a consistent hash ring instead
+// of crc32 % maxNodes, so changing maxNodes only moves about 1/maxNodes of
+// the accounts to another shard rather than nearly all of them. The
+// tradeoff is a slightly less even spread and a ring lookup on every send.
+// The realtime service must route accounts the same way
+func WithConsistentHashing() ClientOption {
+	return func(me *Client) { me.shardFunc = consistentHashShard() }
+}
+
+// WithRendezvousHashing routes accounts with rendezvous (highest random
+// weight) hashing instead of crc32 % maxNodes: each account goes to the
+// node scoring highest for it, so changing maxNodes only moves about
+// 1/maxNodes of the accounts, like WithConsistentHashing but spreading them
+// evenly without a ring. A send computes maxNodes scores. The realtime
+// service must route accounts the same way
+func WithRendezvousHashing() ClientOption {
+	return func(me *Client) { me.shardFunc = rendezvousShard }
+}
+
+// WithRemapMissingShards reroutes the accounts of a shard whose pod doesn't
+// exist (ErrShardUnavailable, e.g. maxNodes drifted above the replica count)
+// to the shards below it, by routing them again as if maxNodes were that
+// shard's index. Without it their sends fail with ErrShardUnavailable. The
+// realtime service must accept accounts it doesn't own to deliver them
+func WithRemapMissingShards() ClientOption {
+	return func(me *Client) { me.remapMissing = true }
+}
+
+// WithReadyOnAllShards makes Ready wait until every shard is connected,
+// instead of any of them
+func WithReadyOnAllShards() ClientOption {
+	return func(me *Client) { me.readyAll = true }
+}
+
+// WithTopicRouting routes every publish by its first topic instead of its
+// account ID, so the messages of a topic shared across accounts all go to
+// the same shard. Topics are routed once prefixed (WithTopicPrefix).
+// ResolveShard, BuildRoutingTable and SendBatch keep routing by account,
+// use WithRoutingKey to route a single publish by another key instead. The
+// realtime service must route the same way
+func WithTopicRouting() ClientOption {
+	return func(me *Client) { me.topicRouting = true }
+}
+
+// WithServiceDiscovery dials the service through the grpc dns resolver
+// (dns:///name:port) and balances calls round robin over every address it
+// resolves to, instead of addressing one StatefulSet pod per shard
+// (name-N.name:port). Use it when the realtime service runs behind a plain
+// Kubernetes Service
+func WithServiceDiscovery() ClientOption {
+	return func(me *Client) { me.discovery = true }
+}
+
+// WithRateLimit limits how fast each account can send, with a token bucket
+// refilled at perAccount tokens per second holding up to burst tokens.
+// Sends over the limit fail with ErrRateLimited, see WithRateLimitWait
+func WithRateLimit(perAccount rate.Limit, burst int) ClientOption {
+	return func(me *Client) {
+		me.rateLimit = perAccount
+		me.rateBurst = burst
+	}
+}
+
+// WithRateLimitWait makes sends over the rate limit wait for a token (or
+// until their context is done) instead of failing with ErrRateLimited
+func WithRateLimitWait() ClientOption {
+	return func(me *Client) { me.rateLimitWait = true }
+}
+
+// WithCircuitBreaker gives every shard a circuit breaker: after threshold
+// failed calls (dial errors, Unavailable or DeadlineExceeded) within window,
+// sends to the shard fail fast with ErrCircuitOpen for cooldown. A single
+// send is then let through to probe whether the shard recovered
+func WithCircuitBreaker(threshold int, window, cooldown time.Duration) ClientOption {
+	return func(me *Client) {
+		me.breakerThreshold = threshold
+		me.breakerWindow = window
+		me.breakerCooldown = cooldown
+	}
+}
+
+// WithMetadata attaches md to every publish RPC. Metadata the caller sets on
+// the context passed to SendContext (metadata.NewOutgoingContext) is kept,
+// values for the same key are sent together
+func WithMetadata(md metadata.MD) ClientOption {
+	return func(me *Client) { me.metadata = metadata.Join(me.metadata, md) }
+}
+
+// WithAddressFormatter overrides how shard addresses are built from the
+// service name and port. The default gives name-N.name:port, the DNS name of
+// pod N of a StatefulSet behind a headless service
+func WithAddressFormatter(f AddressFormatter) ClientOption {
+	return func(me *Client) { me.addressFormatter = f }
+}
+
+// WithTopicPrefix prepends prefix to every topic sent, default topics
+// included, e.g. "staging/" to keep environments apart. The prefix is
+// joined as is: no separator is added, so it should end with one, and a
+// topic already starting with it is prefixed again. Topics are
+// validated (WithTopicValidator) and deduped once prefixed
+func WithTopicPrefix(prefix string) ClientOption {
+	return func(me *Client) { me.topicPrefix = prefix }
+}
+
+// WithErrorOnEmptyTopics makes sends of a message without topics (once the
+// default topics are added) fail with ErrNoTopics, which names the account,
+// instead of silently succeeding without publishing anything
+func WithErrorOnEmptyTopics() ClientOption {
+	return func(me *Client) { me.strictTopics = true }
+}
+
+// WithSerializer sets how SendValue encodes values, e.g. protobuf or
+// msgpack. Defaults to JSONSerializer
+func WithSerializer(s Serializer) ClientOption {
+	return func(me *Client) { me.serializer = s }
+}
+
+// WithDefaultTopics adds topics to every message sent, after the message's
+// own topics, skipping the ones it already has. A message sent without
+// topics then still goes out, to the default topics only
+func WithDefaultTopics(topics []string) ClientOption {
+	return func(me *Client) { me.defaultTopics = append([]string(nil), topics...) }
+}
+
+// WithPodPort sets the port shards are dialed at, name-N.name:port, when
+// the pods listen on another port than the one in the service string. The
+// service's port is then only informational. Defaults to the service's port
+func WithPodPort(port string) ClientOption {
+	return func(me *Client) { me.podPort = port }
+}
+
+// WithIdempotencyKeys tags every publish with a random idempotency key (see
+// SendWithKey), kept the same across retries
+func WithIdempotencyKeys() ClientOption {
+	return func(me *Client) { me.idempotencyKeys = true }
+}
+
+// WithFallback makes sends go to the next shard ((no+1) % maxNodes, and so on
+// up to WithFallbackHops shards) when the account's own shard can't be
+// reached. This is best effort: while falling back, messages of an account
+// may reach nodes that don't serve it and arrive out of order, so only use
+// it when delivering somewhere beats dropping the message
+func WithFallback(enabled bool) ClientOption {
+	return func(me *Client) { me.fallback = enabled }
+}
+
+// WithFallbackHops sets how many shards WithFallback tries after the
+// account's own one. Defaults to 1
+func WithFallbackHops(n int) ClientOption {
+	return func(me *Client) { me.fallbackHops = n }
+}
+
+// WithPublishTimeout bounds every Publish RPC (each retry gets its own
+// timeout). When the caller's context has a sooner deadline, that one wins.
+// By default there is no timeout
+func WithPublishTimeout(d time.Duration) ClientOption {
+	return func(me *Client) { me.publishTimeout = d }
+}
+
+// WithWeights sends each node a share of the accounts proportional to its
+// weight, e.g. []int{2, 1, 1} routes half of the accounts to node 0. There
+// must be one weight, at least 1, per node. It replaces the shard func
+func WithWeights(weights []int) ClientOption {
+	return func(me *Client) { me.weights = append([]int(nil), weights...) }
+}
+
+// WithDisabled creates the client with publishing turned off (when disabled
+// is true), see SetEnabled
+func WithDisabled(disabled bool) ClientOption {
+	return func(me *Client) {
+		if disabled {
+			me.disabled = 1
+		} else {
+			me.disabled = 0
+		}
+	}
+}
+
+// WithPublishHook makes the client call hook around every publish
+func WithPublishHook(hook PublishHook) ClientOption {
+	return func(me *Client) { me.publishHook = hook }
+}
+
+// WithSharedConnection uses a single connection for every account instead of
+// one per shard, relying on the service (and the round robin balancer) to
+// spread the load. It implies WithServiceDiscovery: there is no per-shard
+// addressing, so shard funcs and weights are ignored
+func WithSharedConnection() ClientOption {
+	return func(me *Client) {
+		me.shared = true
+		me.discovery = true
+	}
+}
+
+// WithOrderedDelivery publishes the messages of an account one at a time,
+// in the order Send was called, so they can't overtake each other on the
+// wire. Accounts are still published in parallel. The price is latency: a
+// send waits for every earlier send of its account to complete, or returns
+// its ctx's error unsent if ctx is done first. Sends with an ordering key
+// (WithOrderingKey) are only ordered with the sends of the same account and
+// key. SendAsync keeps the order of its calls too, each of its workers then
+// has its own share of the async queue
+func WithOrderedDelivery() ClientOption {
+	return func(me *Client) { me.sequencer = newSequencer() }
+}
+
+// WithMaxConcurrency bounds how many publishes methods fanning out (such as
+// SendBatch and SendMulti) keep in flight at once, for the whole client.
+// Extra publishes wait for a free slot or until their context is done. By
+// default there is no bound
+func WithMaxConcurrency(n int) ClientOption {
+	return func(me *Client) { me.maxConcurrency = n }
+}
+
+// WithObserver calls f after every publish attempt, including each retry.
+// f runs synchronously on the publishing goroutine, so it should be quick
+func WithObserver(f func(event PublishEvent)) ClientOption {
+	return func(me *Client) { me.observer = f }
+}
+
+// WithLoadBalancingPolicy sets the grpc load balancing policy used by every
+// connection, e.g. "round_robin" or "pick_first". Defaults to round_robin with
+// WithServiceDiscovery, grpc's own default otherwise
+func WithLoadBalancingPolicy(name string) ClientOption {
+	return func(me *Client) { me.lbPolicy = name }
+}
+
+// WithConnectCallback calls f whenever a dial of a shard is over, with the
+// address dialed and the dial error, nil when the connection was
+// established. f is called for the first connection of a shard as well as
+// for every reconnection, including the background ones replacing recycled
+// (WithConnectionMaxAge) or drained connections, without holding any lock
+// of the client
+func WithConnectCallback(f func(shard int, addr string, err error)) ClientOption {
+	return func(me *Client) { me.connectCallback = f }
+}
+
+// WithAccountIDHeader sets the metadata key the account ID of every publish
+// is sent under, AccountIDHeader by default. An empty name stops sending it,
+// AccountIDFromContext keeps working either way
+func WithAccountIDHeader(name string) ClientOption {
+	return func(me *Client) { me.accountIDHeader = name }
+}
+
+// WithUnaryInterceptor adds interceptors around every Publish RPC, e.g. for
+// logging or auth. It can be given several times, interceptors run in the
+// order they were added, the first one being the outermost
+func WithUnaryInterceptor(interceptors ...grpc.UnaryClientInterceptor) ClientOption {
+	return func(me *Client) { me.interceptors = append(me.interceptors, interceptors...) }
+}
+
+// WithPayloadChecksum sends the CRC-32 of every payload under ChecksumHeader
+// so the realtime service can detect corrupted messages. It does nothing
+// unless the server checks the header
+func WithPayloadChecksum() ClientOption {
+	return func(me *Client) { me.checksum = true }
+}
+
+// WithTopicDedup drops repeated topics before publishing, keeping the first
+// occurrence of each, so the realtime service doesn't fan a message out
+// twice to the same topic. The caller's slice is left untouched
+func WithTopicDedup() ClientOption {
+	return func(me *Client) { me.topicDedup = true }
+}
+
+// WithClock replaces the time source of the client, see Clock. It is meant
+// for tests of the timing related features, e.g. a fake clock advancing a
+// retry backoff without sleeping
+func WithClock(c Clock) ClientOption {
+	return func(me *Client) { me.clock = c }
+}
+
+// WithNonBlockingDial makes dials return right away rather than waiting for
+// the connection to be up, the dial timeout is then unused. The first
+// publish to a shard connects within its own deadline, see
+// WithPublishTimeout. Dials block by default
+func WithNonBlockingDial() ClientOption {
+	return func(me *Client) { me.nonBlocking = true }
+}
+
+// WithDialRetry makes a failed dial of a shard be tried again after delay,
+// up to attempts times in total, e.g. to ride out a StatefulSet pod being
+// replaced. It is distinct from WithRetry which retries publishes. Each
+// attempt is bounded by the dial timeout. Dials aren't retried by default
+func WithDialRetry(attempts int, delay time.Duration) ClientOption {
+	return func(me *Client) { me.dialAttempts, me.dialDelay = attempts, delay }
+}
+
+// WithConnectionMaxAge replaces the connection of a shard once it has been
+// open for longer than d. The new connection is dialed in the background
+// on the first send past d, the old one keeps serving meanwhile and is
+// closed a minute after the swap so in-flight publishes can finish.
+// Connections are kept forever by default
+func WithConnectionMaxAge(d time.Duration) ClientOption {
+	return func(me *Client) { me.maxConnAge = d }
+}
+
+// WithMaxTopics rejects messages with more than n topics with
+// ErrTooManyTopics, guarding the realtime service against a huge fan-out.
+// There is no limit by default
+func WithMaxTopics(n int) ClientOption {
+	return func(me *Client) { me.maxTopics = n }
+}
+
+// WithReconnectJitter randomizes reconnections so clients losing their
+// connections at the same time, e.g. when realtime is redeployed, don't dial
+// it all at once: each WithDialRetry wait is drawn in [0, delay] and each
+// connection's WithConnectionMaxAge in [max age / 2, max age]
+func WithReconnectJitter() ClientOption {
+	return func(me *Client) { me.reconnectJitter = true }
+}
+
+// WithMirrorRouting also publishes every message to the shard f picks among
+// maxNodes nodes, e.g. the routing of a new topology with another node
+// count, aggregating the errors of both publishes. Nothing is sent twice
+// when both routings pick the same shard. It is a migration aid: turn it on
+// while the old and new realtime topologies run side by side, switch the
+// primary routing (WithShardFunc, SetMaxNodes) once consumers moved, then
+// drop it. The shards of both routings must be reachable through the
+// client's address formatter
+func WithMirrorRouting(f ShardFunc, maxNodes int) ClientOption {
+	return func(me *Client) { me.mirrorFunc, me.mirrorNodes = f, maxNodes }
+}
+
+// WithUserAgent sets the user agent of every connection, e.g. the name and
+// version of the calling service, so the realtime service can tell clients
+// apart. grpc appends its own user agent. Defaults to
+// subiz-realtime-client/Version
+func WithUserAgent(ua string) ClientOption {
+	return func(me *Client) { me.userAgent = ua }
+}
+
+// WithAckMode tells the realtime service, under AckModeHeader, how far
+// every message must have gone before it replies to the publish. Sends
+// always wait for that reply: AckLeader and AckAll trade latency, up to a
+// replication round trip per publish, for the guarantee that the message
+// landed. The mode only has an effect if the server honors the header. Use
+// WithAck to override it per call
+func WithAckMode(mode AckMode) ClientOption {
+	return func(me *Client) { me.ackMode = mode }
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// OrderingKeyHeader is the metadata key carrying the ordering key of a
+// publish, see WithOrderingKey
+const OrderingKeyHeader = "x-ordering-key"
+
+// WithOrderingKey orders the publish only with the publishes of the same
+// account and key, instead of every publish of the account, when
+// WithOrderedDelivery is set: e.g. the conversations of an account keep
+// their own order without waiting for each other. The shard still follows
+// the account. The key is sent under OrderingKeyHeader as a hint for the
+// server, whether or not WithOrderedDelivery is set
+func WithOrderingKey(key string) SendOption {
+	return func(o *sendOptions) { o.key = key }
+}
+
+// withOrderingKey sets the ordering key of the publish made with ctx
+func withOrderingKey(ctx context.Context, key string) context.Context {
+	o := *sendOptionsOf(ctx)
+	o.key = key
+	return context.WithValue(ctx, sendOptionsKey{}, &o)
+}
+
+// sequenceKey returns the key the sequencer orders a publish of accid made
+// with ctx by
+func sequenceKey(ctx context.Context, accid string) string {
+	if key := sendOptionsOf(ctx).key; key != "" {
+		// the separator can't be confused with a plain account ID
+		return accid + "\x00" + key
+	}
+	return accid
+}
+
+// sequencer runs functions sharing a key one after another, in the order
+// they were submitted, while functions of different keys run in parallel.
+// Each key with pending work has one goroutine, which exits once the key's
+// queue is empty
+type sequencer struct {
+	mu     sync.Mutex
+	queues map[string][]func() // a key is present while its goroutine runs
+}
+
+func newSequencer() *sequencer {
+	return &sequencer{queues: map[string][]func(){}}
+}
+
+// states of a function queued in the sequencer
+const (
+	jobQueued int32 = iota
+	jobStarted
+	jobAbandoned
+)
+
+// do queues fn behind the earlier functions of key and waits until it ran.
+// It returns ctx's error, without running fn, when ctx is done before fn's
+// turn comes: the later functions of key don't wait for a caller who gave
+// up. Once started, fn is waited for, it is meant to return soon after ctx
+// is done
+func (s *sequencer) do(ctx context.Context, key string, fn func()) error {
+	state := jobQueued
+	done := make(chan struct{})
+	job := func() {
+		defer close(done)
+		if atomic.CompareAndSwapInt32(&state, jobQueued, jobStarted) {
+			fn()
+		}
+	}
+
+	s.mu.Lock()
+	q, running := s.queues[key]
+	s.queues[key] = append(q, job)
+	s.mu.Unlock()
+
+	if !running {
+		go s.run(key)
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		if atomic.CompareAndSwapInt32(&state, jobQueued, jobAbandoned) {
+			return ctx.Err()
+		}
+		<-done
+		return nil
+	}
+}
+
+func (s *sequencer) run(key string) {
+	for {
+		s.mu.Lock()
+		q := s.queues[key]
+		if len(q) == 0 {
+			delete(s.queues, key)
+			s.mu.Unlock()
+			return
+		}
+		job := q[0]
+		s.queues[key] = q[1:]
+		s.mu.Unlock()
+
+		job()
+	}
+}
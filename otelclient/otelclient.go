@@ -0,0 +1,51 @@
+// Package otelclient traces realtime client publishes with OpenTelemetry
+package otelclient
+
+import (
+	"context"
+
+	client "github.com/subiz/realtime-client"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+const instrumentationName = "github.com/subiz/realtime-client"
+
+// WithTracing starts a realtime.publish span around every publish, with the
+// account ID, shard, topic count and payload size as attributes, and traces
+// the publish RPCs themselves with otelgrpc. A nil tp uses the global
+// tracer provider
+func WithTracing(tp trace.TracerProvider) client.ClientOption {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	tracer := tp.Tracer(instrumentationName)
+
+	hook := client.WithPublishHook(func(ctx context.Context, info client.PublishInfo) (context.Context, func(error)) {
+		ctx, span := tracer.Start(ctx, "realtime.publish",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("realtime.account_id", info.AccountID),
+				attribute.Int("realtime.shard", info.Shard),
+				attribute.Int("realtime.topic_count", info.Topics),
+				attribute.Int("realtime.payload_size", info.PayloadSize),
+			))
+		return ctx, func(err error) {
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+		}
+	})
+	dial := client.WithDialOptions(grpc.WithStatsHandler(otelgrpc.NewClientHandler(otelgrpc.WithTracerProvider(tp))))
+
+	return func(c *client.Client) {
+		hook(c)
+		dial(c)
+	}
+}
@@ -0,0 +1,180 @@
+package client
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc"
+)
+
+// connPool shares connections between the shards dialed at the same
+// address (e.g. with a custom address formatter), a connection is closed
+// once no shard uses it anymore
+type connPool struct {
+	sync.Mutex
+	byAddr map[string]*grpc.ClientConn // connection handed to new users
+	refs   map[*grpc.ClientConn]int
+	addrs  map[*grpc.ClientConn]string
+	// callers waiting for a dial of the address, see openConn
+	waiting map[string]int
+}
+
+func newConnPool() *connPool {
+	return &connPool{
+		byAddr: map[string]*grpc.ClientConn{},
+		refs:   map[*grpc.ClientConn]int{},
+		addrs:  map[*grpc.ClientConn]string{},
+
+		waiting: map[string]int{},
+	}
+}
+
+// get returns the live connection to addr with a reference taken on it, nil
+// if there is none
+func (p *connPool) get(addr string) *grpc.ClientConn {
+	p.Lock()
+	defer p.Unlock()
+	conn := p.byAddr[addr]
+	if conn == nil || isBroken(conn) {
+		return nil
+	}
+	p.refs[conn]++
+	return conn
+}
+
+// register adds conn, freshly dialed at addr, to the pool. It becomes the
+// connection handed to new users of addr. No reference is taken, users take
+// theirs with ref
+func (p *connPool) register(addr string, conn *grpc.ClientConn) {
+	p.Lock()
+	defer p.Unlock()
+	p.byAddr[addr] = conn
+	p.addrs[conn] = addr
+}
+
+// ref takes another reference on conn, it fails once conn has been closed
+func (p *connPool) ref(conn *grpc.ClientConn) bool {
+	p.Lock()
+	defer p.Unlock()
+	if _, has := p.addrs[conn]; !has {
+		return false
+	}
+	p.refs[conn]++
+	return true
+}
+
+// forget stops handing conn to new users, e.g. because it is unusable. The
+// current users keep it until they release it
+func (p *connPool) forget(conn *grpc.ClientConn) {
+	p.Lock()
+	defer p.Unlock()
+	if addr, has := p.addrs[conn]; has && p.byAddr[addr] == conn {
+		delete(p.byAddr, addr)
+	}
+}
+
+// release drops a reference on conn and closes it when it was the last one
+func (p *connPool) release(conn *grpc.ClientConn) error {
+	p.Lock()
+	p.refs[conn]--
+	if p.refs[conn] > 0 {
+		p.Unlock()
+		return nil
+	}
+
+	if addr := p.addrs[conn]; p.byAddr[addr] == conn {
+		delete(p.byAddr, addr)
+	}
+	delete(p.refs, conn)
+	delete(p.addrs, conn)
+	p.Unlock()
+	return conn.Close()
+}
+
+// wait records a caller waiting for a dial of addr, until it calls done
+func (p *connPool) wait(addr string) {
+	p.Lock()
+	p.waiting[addr]++
+	p.Unlock()
+}
+
+// done records that a caller stopped waiting for a dial of addr
+func (p *connPool) done(addr string) {
+	p.Lock()
+	if p.waiting[addr]--; p.waiting[addr] <= 0 {
+		delete(p.waiting, addr)
+	}
+	p.Unlock()
+}
+
+// closeAbandoned closes conn, freshly dialed at addr, when nobody waits for
+// it nor uses it: the callers of the dial all gave up meanwhile
+func (p *connPool) closeAbandoned(addr string, conn *grpc.ClientConn) bool {
+	p.Lock()
+	if p.waiting[addr] > 0 || p.refs[conn] > 0 {
+		p.Unlock()
+		return false
+	}
+
+	if p.byAddr[addr] == conn {
+		delete(p.byAddr, addr)
+	}
+	delete(p.refs, conn)
+	delete(p.addrs, conn)
+	p.Unlock()
+	conn.Close()
+	return true
+}
+
+// openConn returns a connection to addr for shard no with a reference taken
+// on it, reusing the one of another shard at the same address if any.
+// Concurrent dials of the same address are coalesced, a caller whose ctx
+// ends stops waiting for the shared dial right away
+func (me *Client) openConn(ctx context.Context, no int, addr string) (*grpc.ClientConn, error) {
+	retried := false
+	for {
+		if conn := me.pool.get(addr); conn != nil {
+			return conn, nil
+		}
+
+		me.pool.wait(addr)
+		ch := me.dials.DoChan("addr "+addr, func() (interface{}, error) {
+			conn, err := me.dialRetrying(ctx, no, addr)
+			if err != nil {
+				return nil, err
+			}
+			me.pool.register(addr, conn)
+			if me.pool.closeAbandoned(addr, conn) {
+				return nil, context.Canceled
+			}
+			return conn, nil
+		})
+
+		var res singleflight.Result
+		select {
+		case res = <-ch:
+			me.pool.done(addr)
+		case <-ctx.Done():
+			// the dial goes on for the callers sharing it, it closes the
+			// connection if they all gave up too
+			me.pool.done(addr)
+			return nil, ctx.Err()
+		}
+
+		if res.Err != nil {
+			// the dial was started by another shard whose context ended,
+			// dial once more with ours
+			if res.Shared && !retried && ctx.Err() == nil && isContextError(res.Err) {
+				retried = true
+				continue
+			}
+			return nil, res.Err
+		}
+
+		// every caller sharing the dial takes its own reference
+		if conn := res.Val.(*grpc.ClientConn); me.pool.ref(conn) {
+			return conn, nil
+		}
+	}
+}
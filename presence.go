@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// PresenceHeader is the metadata key carrying the kind of a presence event,
+// PresenceJoin or PresenceLeave, see JoinPresence
+const PresenceHeader = "x-presence"
+
+// Kinds of presence events
+const (
+	PresenceJoin  = "join"
+	PresenceLeave = "leave"
+)
+
+// PresenceEvent is the JSON payload of a presence event
+type PresenceEvent struct {
+	Type     string `json:"type"` // PresenceJoin or PresenceLeave
+	Channel  string `json:"channel"`
+	MemberID string `json:"member_id"`
+	Meta     []byte `json:"meta,omitempty"` // base64 in JSON, only on joins
+}
+
+// PresenceTopic returns the topic the presence events of channel are
+// published to
+func PresenceTopic(channel string) string {
+	return "presence/" + channel
+}
+
+// JoinPresence tells the members of channel that memberID joined it, with
+// meta (e.g. the member's display name) for the others to show. The event
+// is a PresenceEvent published as JSON to PresenceTopic(channel), with
+// ContentTypeHeader set to application/json and PresenceHeader to
+// PresenceJoin. Tracking who is online is up to the realtime service: it
+// must keep the members of the channel from those events (and expire the
+// ones whose client is gone without leaving), the client only publishes
+// them
+func (me *Client) JoinPresence(accid, channel, memberID string, meta []byte) error {
+	return me.sendPresence(accid, PresenceEvent{Type: PresenceJoin, Channel: channel, MemberID: memberID, Meta: meta})
+}
+
+// LeavePresence tells the members of channel that memberID left it, like
+// JoinPresence with PresenceLeave
+func (me *Client) LeavePresence(accid, channel, memberID string) error {
+	return me.sendPresence(accid, PresenceEvent{Type: PresenceLeave, Channel: channel, MemberID: memberID})
+}
+
+func (me *Client) sendPresence(accid string, ev PresenceEvent) error {
+	if ev.Channel == "" || ev.MemberID == "" {
+		return fmt.Errorf("%w: presence needs a channel and a member", ErrInvalidTopic)
+	}
+
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return localRejectf("realtime client: marshal payload: %w", err)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), ContentTypeHeader, "application/json", PresenceHeader, ev.Type)
+	_, err = me.publish(ctx, accid, []string{PresenceTopic(ev.Channel)}, payload)
+	return err
+}
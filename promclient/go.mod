@@ -0,0 +1,10 @@
+module github.com/subiz/realtime-client/promclient
+
+go 1.13
+
+require (
+	github.com/prometheus/client_golang v1.7.1
+	github.com/subiz/realtime-client v0.0.0-00010101000000-000000000000
+)
+
+replace github.com/subiz/realtime-client => ../
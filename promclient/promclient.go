@@ -0,0 +1,68 @@
+// Package promclient exports realtime client metrics to Prometheus. It is a
+// module of its own, depending on the client doesn't pull Prometheus in
+package promclient
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	client "github.com/subiz/realtime-client"
+)
+
+type metrics struct {
+	publishes   *prometheus.CounterVec
+	latency     prometheus.Histogram
+	dials       *prometheus.CounterVec
+	connections *prometheus.GaugeVec
+}
+
+// WithMetrics registers the realtime client metrics on reg and makes the
+// client update them. It panics if the metrics are already registered
+func WithMetrics(reg prometheus.Registerer) client.ClientOption {
+	m := &metrics{
+		publishes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "realtime_client_publishes_total",
+			Help: "Number of publish RPCs, by result.",
+		}, []string{"result"}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "realtime_client_publish_duration_seconds",
+			Help:    "Latency of publish RPCs.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		dials: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "realtime_client_dials_total",
+			Help: "Number of attempts to connect to a shard, by result.",
+		}, []string{"result"}),
+		connections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "realtime_client_connections",
+			Help: "Whether the client holds a live connection to a shard.",
+		}, []string{"shard"}),
+	}
+	reg.MustRegister(m.publishes, m.latency, m.dials, m.connections)
+	return client.WithMetricsCollector(m)
+}
+
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+func (m *metrics) ObservePublish(shard int, d time.Duration, err error) {
+	m.publishes.WithLabelValues(result(err)).Inc()
+	m.latency.Observe(d.Seconds())
+}
+
+func (m *metrics) ObserveDial(shard int, err error) {
+	m.dials.WithLabelValues(result(err)).Inc()
+}
+
+func (m *metrics) SetConnected(shard int, connected bool) {
+	v := 0.0
+	if connected {
+		v = 1
+	}
+	m.connections.WithLabelValues(strconv.Itoa(shard)).Set(v)
+}
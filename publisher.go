@@ -0,0 +1,12 @@
+package client
+
+import "context"
+
+// Publisher is the publishing side of Client. Depend on it instead of
+// *Client to be able to swap in a fake (see the clienttest package) in tests
+type Publisher interface {
+	Send(accid string, topics []string, payload []byte) error
+	SendContext(ctx context.Context, accid string, topics []string, payload []byte) error
+}
+
+var _ Publisher = (*Client)(nil)
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// limiters of accounts that haven't sent for this long are dropped
+	limiterIdleTimeout = 5 * time.Minute
+	limiterSweepPeriod = time.Minute
+)
+
+// ErrRateLimited is returned when an account sends faster than the limit
+// set with WithRateLimit
+var ErrRateLimited = newLocalReject("realtime client: rate limited")
+
+type accountLimiter struct {
+	*rate.Limiter
+	lastUsed time.Time
+}
+
+// limiters holds one token bucket per account
+type limiters struct {
+	sync.Mutex
+	limit     rate.Limit
+	burst     int
+	accounts  map[string]*accountLimiter
+	lastSweep time.Time
+	clock     Clock
+}
+
+func newLimiters(limit rate.Limit, burst int, clock Clock) *limiters {
+	return &limiters{limit: limit, burst: burst, accounts: map[string]*accountLimiter{}, lastSweep: clock.Now(), clock: clock}
+}
+
+// get returns the limiter of an account, creating it if needed. Idle
+// limiters are garbage collected along the way
+func (l *limiters) get(accid string) *rate.Limiter {
+	now := l.clock.Now()
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.lastSweep) > limiterSweepPeriod {
+		for id, lim := range l.accounts {
+			if now.Sub(lim.lastUsed) > limiterIdleTimeout {
+				delete(l.accounts, id)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	lim := l.accounts[accid]
+	if lim == nil {
+		lim = &accountLimiter{Limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.accounts[accid] = lim
+	}
+	lim.lastUsed = now
+	return lim.Limiter
+}
+
+// checkRateLimit takes a token from the account's bucket. It either fails
+// with ErrRateLimited or waits for a token, see WithRateLimitWait
+func (me *Client) checkRateLimit(ctx context.Context, accid string) error {
+	if me.limiters == nil {
+		return nil
+	}
+
+	lim := me.limiters.get(accid)
+	if me.rateLimitWait {
+		return lim.Wait(ctx)
+	}
+
+	if !lim.AllowN(me.clock.Now(), 1) {
+		return fmt.Errorf("%w: account %s", ErrRateLimited, accid)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// recycleGrace is how long a recycled connection stays open so the
+// publishes still running over it can finish
+const recycleGrace = time.Minute
+
+// releaseLater releases conn after recycleGrace, so the publishes still
+// running over it can finish. Close doesn't wait: it releases the pending
+// connections right away
+func (me *Client) releaseLater(conn *grpc.ClientConn) {
+	me.releasesMu.Lock()
+	defer me.releasesMu.Unlock()
+	if atomic.LoadInt32(&me.closed) == 1 {
+		me.pool.release(conn)
+		return
+	}
+
+	var t *time.Timer
+	t = time.AfterFunc(recycleGrace, func() {
+		me.releasesMu.Lock()
+		_, pending := me.releases[t]
+		delete(me.releases, t)
+		me.releasesMu.Unlock()
+		if pending {
+			me.pool.release(conn)
+		}
+	})
+	me.releases[t] = conn
+}
+
+// flushReleases releases now the connections waiting for their grace period
+// to end, it returns the errors closing them
+func (me *Client) flushReleases() []error {
+	me.releasesMu.Lock()
+	releases := me.releases
+	me.releases = map[*time.Timer]*grpc.ClientConn{}
+	me.releasesMu.Unlock()
+
+	var errs []error
+	for t, conn := range releases {
+		t.Stop()
+		if err := me.pool.release(conn); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// connExpiry returns when a connection established at now gets recycled,
+// zero without WithConnectionMaxAge. With WithReconnectJitter it is drawn
+// in [max age / 2, max age] so connections dialed together aren't all
+// recycled at once
+func (me *Client) connExpiry(now time.Time) time.Time {
+	if me.maxConnAge <= 0 {
+		return time.Time{}
+	}
+
+	age := me.maxConnAge
+	if me.reconnectJitter {
+		age = age/2 + jitter(age/2)
+	}
+	return now.Add(age)
+}
+
+// maybeRecycle starts replacing the connection of shard no once it is past
+// expires, see WithConnectionMaxAge. The new connection is dialed in the
+// background, callers keep using the old one meanwhile. It never takes the
+// shard lock
+func (me *Client) maybeRecycle(no int, s *shard, expires time.Time) {
+	if expires.IsZero() || me.clock.Now().Before(expires) {
+		return
+	}
+
+	if !atomic.CompareAndSwapInt32(&s.recycling, 0, 1) {
+		return
+	}
+	go me.recycle(no, s)
+}
+
+// recycle swaps a new connection in for shard no, the old connection is
+// released after recycleGrace. The new connection is shared through the
+// pool: the first shard at an address to recycle dials it, the others
+// still holding the old connection move to it as they recycle theirs
+func (me *Client) recycle(no int, s *shard) {
+	defer atomic.StoreInt32(&s.recycling, 0)
+
+	addr, err := me.address(no)
+	if err != nil {
+		return
+	}
+
+	s.Lock()
+	old := s.conn
+	s.Unlock()
+	if old != nil {
+		// reuse the connection another shard at addr swapped in after old,
+		// if any, rather than old itself
+		me.pool.forget(old)
+	}
+
+	conn, err := me.openConn(context.Background(), no, addr)
+	if err != nil {
+		me.notifyConnect(no, addr, &DialError{Shard: no, Addr: addr, Err: err})
+		// keep the old connection, try again after another max age
+		s.Lock()
+		if s.conn != nil {
+			s.postpone(me.connExpiry(me.clock.Now()))
+		}
+		s.Unlock()
+		return
+	}
+
+	s.Lock()
+	if atomic.LoadInt32(&me.closed) == 1 || s.removed || s.conn == nil {
+		// closed, removed or dropped meanwhile: the next send dials anyway
+		s.Unlock()
+		me.pool.release(conn)
+		me.notifyConnect(no, addr, nil)
+		return
+	}
+
+	old = s.conn
+	now := me.clock.Now()
+	s.set(conn, now, me.connExpiry(now))
+	s.addr = addr
+	me.watchConn(no, s)
+	me.watchDrain(no, s)
+	s.Unlock()
+
+	me.logger.Printf("realtime client: recycled the connection of shard %d to %s", no, addr)
+	me.notifyConnect(no, addr, nil)
+	me.releaseLater(old)
+}
@@ -0,0 +1,80 @@
+package client_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	client "github.com/subiz/realtime-client"
+	"github.com/subiz/realtime-client/clienttest"
+)
+
+// offsetClock is a real clock whose Now can be moved forward
+type offsetClock struct {
+	mu     sync.Mutex
+	offset time.Duration
+}
+
+func (c *offsetClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Add(c.offset)
+}
+
+func (c *offsetClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (c *offsetClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.offset += d
+	c.mu.Unlock()
+}
+
+// dialCounter is a client.Metrics counting the successful dials
+type dialCounter struct{ dials int32 }
+
+func (m *dialCounter) ObservePublish(shard int, d time.Duration, err error) {}
+func (m *dialCounter) SetConnected(shard int, connected bool)               {}
+
+func (m *dialCounter) ObserveDial(shard int, err error) {
+	if err == nil {
+		atomic.AddInt32(&m.dials, 1)
+	}
+}
+
+func TestRecycleSharesConnection(t *testing.T) {
+	clock, metrics := &offsetClock{}, &dialCounter{}
+	sameAddr := func(baseName, port string, index int) string { return "realtime:8080" }
+	c, srv := clienttest.NewInMemory(client.WithAddressFormatter(sameAddr), client.WithConnectionMaxAge(time.Hour),
+		client.WithClock(clock), client.WithMetricsCollector(metrics))
+	defer srv.Close()
+	defer c.Close(context.Background())
+	if err := c.SetMaxNodes(2); err != nil {
+		t.Fatalf("SetMaxNodes: %v", err)
+	}
+
+	send := func(shard int) {
+		t.Helper()
+		if err := c.SendToShard(shard, "acc1", []string{"t"}, []byte("m")); err != nil {
+			t.Fatalf("SendToShard(%d): %v", shard, err)
+		}
+	}
+	send(0)
+	send(1)
+	if got := atomic.LoadInt32(&metrics.dials); got != 1 {
+		t.Fatalf("%d dials for two shards at one address, want 1", got)
+	}
+
+	clock.advance(2 * time.Hour)
+	for shard := 0; shard < 2; shard++ {
+		since := c.ConnectionStats()[shard].EstablishedAt
+		send(shard)
+		waitFor(t, "the recycle", func() bool { return c.ConnectionStats()[shard].EstablishedAt.After(since) })
+	}
+	if got := atomic.LoadInt32(&metrics.dials); got != 2 {
+		t.Fatalf("%d dials after both shards recycled, want 2: they must share the new connection", got)
+	}
+	send(0)
+	send(1)
+}
@@ -0,0 +1,164 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrShardRemoved is returned by sends routed to a shard that SetMaxNodes
+// (or SetService) has removed while they were in flight
+var ErrShardRemoved = newLocalReject("realtime client: shard removed")
+
+// SetMaxNodes changes the number of nodes accounts are spread over while the
+// client is running, e.g. after the realtime tier is scaled. New shards are
+// dialed lazily like the initial ones, the connections of removed shards are
+// closed and sends still in flight to them fail with ErrShardRemoved.
+//
+// Changing the node count reshuffles accounts: with the default crc32
+// routing most accounts move to another node, WithConsistentHashing only
+// moves about 1/n of them. Messages of a moving account may briefly be
+// published to both its old and new node. SetMaxNodes fails when WithWeights
+// is set since the weights are tied to the node count
+func (me *Client) SetMaxNodes(n int) error {
+	if n < 1 {
+		return fmt.Errorf("realtime client: maxNodes must be at least 1, got %d", n)
+	}
+	if me.weights != nil {
+		return errors.New("realtime client: can't change maxNodes with WithWeights")
+	}
+
+	me.nodesMu.Lock()
+	cur := me.nodes.Load().(*nodes)
+	size := me.shardCount(n)
+	if size == len(cur.shards) {
+		me.nodes.Store(&nodes{shards: cur.shards, maxNodes: n, service: cur.service})
+		me.nodesMu.Unlock()
+		me.logNodes(cur.maxNodes, n)
+		return nil
+	}
+
+	shards := make([]*shard, size)
+	copy(shards, cur.shards)
+	for i := len(cur.shards); i < size; i++ {
+		shards[i] = me.newShard()
+	}
+	var removed []*shard
+	if size < len(cur.shards) {
+		removed = cur.shards[size:]
+	}
+	me.nodes.Store(&nodes{shards: shards, maxNodes: n, service: cur.service})
+	me.nodesMu.Unlock()
+
+	me.removeShards(removed, size)
+	me.logNodes(cur.maxNodes, n)
+	return nil
+}
+
+// SetService moves the client to another realtime service while it is
+// running, e.g. to cut over to a new cluster in a blue-green deployment.
+// service must be in host:port form like the one given to NewClient. Every
+// shard starts over: the connections to the old service are closed, sends
+// still in flight over them fail, and the next sends dial the new service
+// lazily. It is safe to call concurrently with sends
+func (me *Client) SetService(service string) error {
+	if _, _, err := splitService(service); err != nil {
+		return err
+	}
+
+	me.nodesMu.Lock()
+	cur := me.nodes.Load().(*nodes)
+	shards := make([]*shard, len(cur.shards))
+	for i := range shards {
+		shards[i] = me.newShard()
+	}
+	me.nodes.Store(&nodes{shards: shards, maxNodes: cur.maxNodes, service: service})
+	me.nodesMu.Unlock()
+
+	me.removeShards(cur.shards, 0)
+	if cur.service != service {
+		me.logger.Printf("realtime client: service changed from %s to %s", cur.service, service)
+	}
+	return nil
+}
+
+// removeShards closes the connections of shards, no longer in the client,
+// which were numbered from first on. Dials still running for them release
+// their connection once done, see storeConn
+func (me *Client) removeShards(shards []*shard, first int) {
+	for i, s := range shards {
+		s.Lock()
+		s.removed = true
+		if s.conn != nil {
+			me.pool.release(s.conn)
+			me.metrics.SetConnected(first+i, false)
+		}
+		s.drop()
+		s.Unlock()
+	}
+}
+
+func (me *Client) logNodes(old, n int) {
+	if old != n {
+		me.logger.Printf("realtime client: maxNodes changed from %d to %d", old, n)
+	}
+}
+
+// newShard returns an empty shard, with a breaker if WithCircuitBreaker is
+// set
+func (me *Client) newShard() *shard {
+	s := &shard{}
+	if me.breakerThreshold > 0 {
+		s.breaker = newBreaker(me.breakerThreshold, me.breakerWindow, me.breakerCooldown, me.clock)
+	}
+	return s
+}
+
+// nodes is the set of shards accounts are spread over. It is never modified
+// once stored in the client, SetMaxNodes stores a new one instead, so it is
+// read without locking
+type nodes struct {
+	shards   []*shard
+	maxNodes int    // len(shards) unless shared or mirrored, see shardCount
+	service  string // eg: realtime:48883
+}
+
+// shardCount returns how many shards the client needs to spread accounts
+// over maxNodes nodes
+func (me *Client) shardCount(maxNodes int) int {
+	if me.shared {
+		return 1
+	}
+	if me.mirrorFunc != nil && me.mirrorNodes > maxNodes {
+		return me.mirrorNodes
+	}
+	return maxNodes
+}
+
+// shardAt returns shard no, or ErrShardRemoved if SetMaxNodes has removed it
+func (me *Client) shardAt(no int) (*shard, error) {
+	shards := me.shardList()
+	if no < 0 || no >= len(shards) {
+		return nil, fmt.Errorf("%w: %d", ErrShardRemoved, no)
+	}
+	return shards[no], nil
+}
+
+// shardList returns the current shards
+func (me *Client) shardList() []*shard {
+	return me.nodes.Load().(*nodes).shards
+}
+
+// primaryShards returns the current shards accounts are routed to, without
+// the ones past maxNodes that only WithMirrorRouting publishes to
+func (me *Client) primaryShards() []*shard {
+	n := me.nodes.Load().(*nodes)
+	if len(n.shards) > n.maxNodes {
+		return n.shards[:n.maxNodes]
+	}
+	return n.shards
+}
+
+// nodeCount returns the number of nodes accounts are spread over
+func (me *Client) nodeCount() int {
+	return me.nodes.Load().(*nodes).maxNodes
+}
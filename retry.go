@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/subiz/header"
+	pb "github.com/subiz/header/realtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryError is returned when a publish still failed after being retried.
+// Attempts counts every try, including the first one
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("publish failed after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error { return e.Err }
+
+// isRetryable tells whether a failed publish is worth trying again
+func isRetryable(err error) bool {
+	switch codeOf(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// RetryRule is how publishes failing with a grpc code are retried, see
+// WithRetryPolicy
+type RetryRule struct {
+	MaxAttempts int           // tries in total, counting the first one, 1 never retries
+	Backoff     time.Duration // wait before the first retry, doubled after each
+}
+
+// retryRule returns how a publish that failed with err is retried. The zero
+// rule never retries
+func (me *Client) retryRule(err error) RetryRule {
+	if me.retryPolicy != nil {
+		return me.retryPolicy[codeOf(err)]
+	}
+	if isRetryable(err) {
+		return RetryRule{MaxAttempts: me.retryAttempts, Backoff: me.retryBackoff}
+	}
+	return RetryRule{}
+}
+
+// callPublish calls Publish on client, retrying failures as told by
+// retryRule. The backoff doubles after each attempt and never waits past
+// ctx. An Unavailable error drops the shard's connection if it isn't ready
+// anymore (see dropClient), a retry then dials again
+func (me *Client) callPublish(ctx context.Context, shard int, client header.PubsubClient, msg *pb.PublishMessage) (res proto.Message, err error) {
+	defer func() { me.countPublish(shard, len(msg.Payload), err) }()
+
+	ctx = me.withAckMode(me.withChecksum(me.withAccountID(ctx, msg.AccountId), msg.Payload))
+	if me.contextFunc != nil {
+		ctx = me.contextFunc(ctx, msg.AccountId)
+	}
+	attempt := 1
+	for {
+		start := me.clock.Now()
+		res, err = me.publishOnce(ctx, client, msg)
+		d := me.clock.Now().Sub(start)
+		me.metrics.ObservePublish(shard, d, err)
+		me.observe(PublishEvent{
+			AccountID:   msg.AccountId,
+			Shard:       shard,
+			Topics:      len(msg.Topics),
+			PayloadSize: len(msg.Payload),
+			Duration:    d,
+			Attempt:     attempt,
+			Err:         err,
+			Unhealthy:   me.shardUnhealthy(shard),
+		})
+		if err == nil {
+			return res, nil
+		}
+
+		if status.Code(err) == codes.Unavailable {
+			me.dropClient(shard, client)
+		}
+
+		rule := me.retryRule(err)
+		if attempt >= rule.MaxAttempts || ctx.Err() != nil {
+			if attempt > 1 {
+				return nil, &RetryError{Attempts: attempt, Err: err}
+			}
+			return nil, err
+		}
+
+		if WaitBackoff(ctx, me.clock, attempt, rule.Backoff, 0) != nil {
+			return nil, &RetryError{Attempts: attempt, Err: err}
+		}
+		attempt++
+
+		if status.Code(err) == codes.Unavailable {
+			if client, err = me.shardClient(ctx, shard); err != nil {
+				return nil, &RetryError{Attempts: attempt - 1, Err: err}
+			}
+		}
+	}
+}
+
+// publishOnce issues a single Publish RPC, bounded by the publish timeout
+// (or the call's WithCallDeadline) when one is set. The sooner of ctx's
+// deadline and the timeout wins
+func (me *Client) publishOnce(ctx context.Context, client header.PubsubClient, msg *pb.PublishMessage) (proto.Message, error) {
+	if timeout := me.publishTimeoutOf(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	opts := me.callOptions
+	if ready := sendOptionsOf(ctx).ready; len(ready) > 0 {
+		// appended last so it wins over the client's
+		opts = append(opts[:len(opts):len(opts)], ready...)
+	}
+	res, err := client.Publish(ctx, msg, opts...)
+	if err != nil {
+		return nil, &RPCError{Err: err}
+	}
+	return res, nil
+}
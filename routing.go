@@ -0,0 +1,247 @@
+package client
+
+import (
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// ShardFunc picks the shard (node index) an account publishes to. It must
+// return a value in [0, maxNodes)
+type ShardFunc func(accid string, maxNodes int) int
+
+// ShardIndex is the default routing of the client: the shard an account
+// publishes to out of maxNodes nodes, without WithShardFunc or any other
+// routing option. It lets tests and tools predict placement without
+// building a Client. maxNodes must be at least 1
+func ShardIndex(accid string, maxNodes int) int {
+	// modulo on the unsigned hash: int(hash) is negative for hashes with the
+	// high bit set where int is 32 bits
+	return int(crc32.ChecksumIEEE([]byte(accid)) % uint32(maxNodes))
+}
+
+// hashShard returns a ShardFunc routing accounts to hash(accid) % maxNodes
+func hashShard(hash func(accid []byte) uint32) ShardFunc {
+	return func(accid string, maxNodes int) int {
+		return int(hash([]byte(accid)) % uint32(maxNodes))
+	}
+}
+
+// shardOf returns the shard an account is routed to
+func (me *Client) shardOf(accid string) (int, error) {
+	if me.shared {
+		return 0, nil
+	}
+
+	maxNodes := me.nodeCount()
+	if maxNodes < 1 {
+		// the modulo of the shard func would panic
+		return 0, localRejectf("realtime client: can't route account %s, maxNodes is %d", accid, maxNodes)
+	}
+
+	no := me.shardFunc(accid, maxNodes)
+	if no < 0 || no >= maxNodes {
+		return 0, localRejectf("realtime client: shard func returned %d for account %s, want a value in [0, %d)", no, accid, maxNodes)
+	}
+	return no, nil
+}
+
+// WithRoutingKey routes the publish by key instead of the account ID: the
+// shard is the one an account with ID key would get, e.g. to keep the
+// traffic of a topic shared by many accounts on a single shard. It wins
+// over WithTopicRouting. The account ID is still sent, and orders the
+// publish with WithOrderedDelivery
+func WithRoutingKey(key string) SendOption {
+	return func(o *sendOptions) { o.route = key }
+}
+
+// routingKey returns what a publish to topics of accid is routed by
+func (me *Client) routingKey(o *sendOptions, accid string, topics []string) string {
+	if o.route != "" {
+		return o.route
+	}
+	if me.topicRouting && len(topics) > 0 {
+		return topics[0]
+	}
+	return accid
+}
+
+// ResolveShard tells which shard an account publishes to and the address
+// that shard is dialed at, using the client's current routing and addressing
+// options. Nothing is dialed. It returns -1 and an empty address when the
+// shard func returns an out of range index
+func (me *Client) ResolveShard(accid string) (index int, address string) {
+	no, err := me.shardOf(accid)
+	if err != nil {
+		return -1, ""
+	}
+
+	addr, err := me.address(no)
+	if err != nil {
+		return -1, ""
+	}
+	return no, addr
+}
+
+// ShardInfo is where an account is routed, see BuildRoutingTable
+type ShardInfo struct {
+	Shard   int    // -1 when the account can't be routed
+	Address string // empty when the account can't be routed
+}
+
+// BuildRoutingTable resolves the shard of every account like ResolveShard
+// does, without dialing anything
+func (me *Client) BuildRoutingTable(accids []string) map[string]ShardInfo {
+	table := make(map[string]ShardInfo, len(accids))
+	for _, accid := range accids {
+		no, addr := me.ResolveShard(accid)
+		table[accid] = ShardInfo{Shard: no, Address: addr}
+	}
+	return table
+}
+
+// address returns the target to dial for a shard
+func (me *Client) address(no int) (string, error) {
+	service := me.nodes.Load().(*nodes).service
+	if me.discovery {
+		return "dns:///" + service, nil
+	}
+
+	name, port, err := splitService(service)
+	if err != nil {
+		return "", err
+	}
+	if me.podPort != "" {
+		port = me.podPort
+	}
+	return me.addressFormatter(name, port, no), nil
+}
+
+// AddressFormatter builds the address of a shard from the name and port of
+// the service
+type AddressFormatter func(baseName, port string, index int) string
+
+// statefulSetAddress addresses the pods of a StatefulSet through its
+// headless service
+func statefulSetAddress(name, port string, no int) string {
+	// address: [pod name] + "." + [service name] + ":" + [pod port]
+	return name + "-" + strconv.Itoa(no) + "." + name + ":" + port
+}
+
+// ringReplicas is the number of points each node owns on the hash ring, more
+// points spread accounts more evenly at the cost of a bigger ring
+const ringReplicas = 128
+
+// hashRing is a consistent hash ring over nodes [0, size)
+type hashRing struct {
+	size   int
+	points []uint32 // sorted
+	nodes  []int    // nodes[i] owns points[i]
+}
+
+func newHashRing(size int) *hashRing {
+	type point struct {
+		hash uint32
+		node int
+	}
+
+	all := make([]point, 0, size*ringReplicas)
+	for node := 0; node < size; node++ {
+		for i := 0; i < ringReplicas; i++ {
+			key := strconv.Itoa(node) + "#" + strconv.Itoa(i)
+			all = append(all, point{hash: crc32.ChecksumIEEE([]byte(key)), node: node})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].hash < all[j].hash })
+
+	r := &hashRing{size: size, points: make([]uint32, len(all)), nodes: make([]int, len(all))}
+	for i, p := range all {
+		r.points[i], r.nodes[i] = p.hash, p.node
+	}
+	return r
+}
+
+// get returns the node owning the first point at or after the key's hash
+func (r *hashRing) get(key string) int {
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.nodes[i]
+}
+
+// consistentHashShard returns a ShardFunc backed by a hash ring. The ring is
+// rebuilt whenever maxNodes changes
+func consistentHashShard() ShardFunc {
+	var mu sync.Mutex
+	var ring *hashRing
+	return func(accid string, maxNodes int) int {
+		mu.Lock()
+		if ring == nil || ring.size != maxNodes {
+			ring = newHashRing(maxNodes)
+		}
+		r := ring
+		mu.Unlock()
+		return r.get(accid)
+	}
+}
+
+// rendezvousShard is a ShardFunc picking the node with the highest score
+// for the account (rendezvous hashing). The account is hashed once with
+// FNV-1a, each node's score mixes that hash with the node index
+func rendezvousShard(accid string, maxNodes int) int {
+	h := fnv.New64a()
+	h.Write([]byte(accid))
+	sum := h.Sum64()
+
+	best, bestScore := 0, uint64(0)
+	for node := 0; node < maxNodes; node++ {
+		if score := mix64(sum + uint64(node)*0x9e3779b97f4a7c15); node == 0 || score > bestScore {
+			best, bestScore = node, score
+		}
+	}
+	return best
+}
+
+// mix64 is the splitmix64 finalizer, it spreads every input bit over the
+// whole output
+func mix64(x uint64) uint64 {
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// weightedShard returns a ShardFunc routing accounts to node i with a
+// probability proportional to weights[i]. An account always lands on the
+// same node as long as the weights don't change
+func weightedShard(weights []int) ShardFunc {
+	bounds := make([]uint32, len(weights)) // bounds[i] is the end of node i's range
+	var total uint32
+	for i, w := range weights {
+		total += uint32(w)
+		bounds[i] = total
+	}
+
+	return func(accid string, maxNodes int) int {
+		h := crc32.ChecksumIEEE([]byte(accid)) % total
+		return sort.Search(len(bounds), func(i int) bool { return bounds[i] > h })
+	}
+}
+
+// checkWeights validates the weights given to WithWeights
+func checkWeights(weights []int, maxNodes int) error {
+	if len(weights) != maxNodes {
+		return fmt.Errorf("realtime client: got %d weights for %d nodes", len(weights), maxNodes)
+	}
+
+	for i, w := range weights {
+		if w < 1 {
+			return fmt.Errorf("realtime client: weight of node %d must be at least 1, got %d", i, w)
+		}
+	}
+	return nil
+}
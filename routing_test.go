@@ -0,0 +1,82 @@
+package client_test
+
+import (
+	"context"
+	"hash/crc32"
+	"strconv"
+	"testing"
+
+	client "github.com/subiz/realtime-client"
+	"github.com/subiz/realtime-client/clienttest"
+)
+
+func TestShardIndex(t *testing.T) {
+	tests := []struct {
+		accid    string
+		maxNodes int
+		want     int
+	}{
+		// the shards int(crc32) % maxNodes gave where int is 64 bits, which
+		// routing must keep
+		{"acc2", 10, 1},     // crc 0x6e4853e7
+		{"acc3", 7, 6},      // crc 0x194f6371
+		{"acc0", 10, 1},     // crc 0x804632cb, high bit set
+		{"acc1", 7, 2},      // crc 0xf741025d, high bit set
+		{"acc4", 1000, 394}, // crc 0x872bf6d2, high bit set
+		{"acc5", 3, 1},      // crc 0xf02cc644, high bit set
+		{"a", 1000, 907},    // crc 0xe8b7be43, high bit set
+		{"", 10, 0},
+		{"acc1", 1, 0},
+	}
+	for _, tt := range tests {
+		if got := client.ShardIndex(tt.accid, tt.maxNodes); got != tt.want {
+			t.Errorf("ShardIndex(%q, %d) = %d, want %d", tt.accid, tt.maxNodes, got, tt.want)
+		}
+	}
+}
+
+func TestShardIndexInRange(t *testing.T) {
+	highBit := 0
+	for i := 0; i < 10000; i++ {
+		accid := "acc" + strconv.Itoa(i)
+		if crc32.ChecksumIEEE([]byte(accid))&(1<<31) != 0 {
+			highBit++
+		}
+		for _, maxNodes := range []int{1, 2, 3, 7, 10, 1000, 1<<31 - 1} {
+			if no := client.ShardIndex(accid, maxNodes); no < 0 || no >= maxNodes {
+				t.Fatalf("ShardIndex(%q, %d) = %d, out of [0, %d)", accid, maxNodes, no, maxNodes)
+			}
+		}
+	}
+	if highBit == 0 {
+		t.Fatal("no account ID with the high bit of its crc set")
+	}
+}
+
+func TestMirrorShardsNotPrimary(t *testing.T) {
+	mirror := func(accid string, maxNodes int) int { return 2 }
+	c, m := clienttest.NewInMemory(client.WithMirrorRouting(mirror, 3))
+	defer m.Close()
+	defer c.Close(context.Background())
+
+	if err := c.Broadcast([]string{"t"}, []byte("all")); err != nil {
+		t.Fatalf("Broadcast: %v", err)
+	}
+	if got := len(m.Published()); got != 1 {
+		t.Fatalf("Broadcast published %d messages, want 1 for the single primary shard", got)
+	}
+	if err := c.SendToShard(2, "acc1", []string{"t"}, []byte("pinned")); err == nil {
+		t.Fatal("SendToShard(2) succeeded on a mirror-only shard, want out of range")
+	}
+	if res := c.HealthCheck(context.Background()); len(res) != 1 {
+		t.Fatalf("HealthCheck checked %d shards, want 1", len(res))
+	}
+
+	m.Reset()
+	if err := c.Send("acc1", []string{"t"}, []byte("mirrored")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got := len(m.Published()); got != 2 {
+		t.Fatalf("Send published %d messages, want 2 with the mirror copy", got)
+	}
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// SendOption configures a single publish, see SendWithOptions
+type SendOption func(*sendOptions)
+
+type sendOptions struct {
+	timeout time.Duration // replaces the publish timeout when > 0
+	pinned  bool          // publish to shard, whatever the account's shard is
+	routed  bool          // shard is the account's shard, already computed
+	shard   int
+	ack     AckMode           // replaces the client's ack mode unless AckDefault
+	key     string            // ordering key, see WithOrderingKey
+	route   string            // routing key, see WithRoutingKey
+	resend  bool              // sent from the durable buffer, never spilled again
+	ready   []grpc.CallOption // WaitForReady, when set for the call
+}
+
+type sendOptionsKey struct{}
+
+// WithCallDeadline bounds each Publish RPC of this call by d, overriding the
+// client wide WithPublishTimeout, e.g. a tight deadline for a typing
+// indicator. A sooner deadline on the caller's context still wins
+func WithCallDeadline(d time.Duration) SendOption {
+	return func(o *sendOptions) { o.timeout = d }
+}
+
+// WithCallWaitForReady overrides WithWaitForReady for this call, e.g. to
+// let a message that isn't latency critical wait for its shard
+func WithCallWaitForReady(wait bool) SendOption {
+	return func(o *sendOptions) { o.ready = []grpc.CallOption{grpc.WaitForReady(wait)} }
+}
+
+// SendWithOptions is like SendContext but applies per call options
+func (me *Client) SendWithOptions(ctx context.Context, accid string, topics []string, payload []byte, opts ...SendOption) error {
+	o := &sendOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.key != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, OrderingKeyHeader, o.key)
+	}
+	_, err := me.publish(context.WithValue(ctx, sendOptionsKey{}, o), accid, topics, payload)
+	return err
+}
+
+// sendOptionsOf returns the per call options ctx carries
+func sendOptionsOf(ctx context.Context) *sendOptions {
+	if o, _ := ctx.Value(sendOptionsKey{}).(*sendOptions); o != nil {
+		return o
+	}
+	return &sendOptions{}
+}
+
+// publishTimeoutOf returns the timeout of a single Publish RPC made with ctx
+func (me *Client) publishTimeoutOf(ctx context.Context) time.Duration {
+	if o := sendOptionsOf(ctx); o.timeout > 0 {
+		return o.timeout
+	}
+	return me.publishTimeout
+}
+
+// SendToShard publishes over the connection of the given shard, regardless
+// of the shard the account is routed to. It is an escape hatch for
+// operational tooling, e.g. replaying messages to a specific pod: the
+// realtime service may not expect the account on that shard. There is no
+// fallback to other shards, every other client side check still applies
+func (me *Client) SendToShard(shard int, accid string, topics []string, payload []byte) error {
+	if n := len(me.primaryShards()); shard < 0 || shard >= n {
+		return localRejectf("realtime client: shard %d out of range [0, %d)", shard, n)
+	}
+	if err := me.checkAccount(accid); err != nil {
+		return err
+	}
+	ctx := context.WithValue(context.Background(), sendOptionsKey{}, &sendOptions{pinned: true, shard: shard})
+	_, err := me.publish(ctx, accid, topics, payload)
+	return err
+}
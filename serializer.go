@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Serializer encodes the values published with SendValue, see
+// WithSerializer
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+
+	// ContentType is the media type of the encoded values, sent under
+	// ContentTypeHeader
+	ContentType() string
+}
+
+// JSONSerializer encodes values with encoding/json, it is the default
+// serializer
+type JSONSerializer struct{}
+
+func (JSONSerializer) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (JSONSerializer) ContentType() string { return "application/json" }
+
+// SendValue is like Send but publishes v encoded by the client's serializer
+// (JSON unless WithSerializer is set), tagged with ContentTypeHeader set to
+// its content type. It returns the marshal error without publishing
+// anything if v can't be encoded
+func (me *Client) SendValue(accid string, topics []string, v interface{}) error {
+	payload, err := me.serializer.Marshal(v)
+	if err != nil {
+		return localRejectf("realtime client: marshal payload: %w", err)
+	}
+	if payload == nil {
+		// e.g. an empty message of a binary format, it is still a message
+		payload = []byte{}
+	}
+
+	ctx := context.Background()
+	if ct := me.serializer.ContentType(); ct != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, ContentTypeHeader, ct)
+	}
+	_, err = me.publish(ctx, accid, topics, payload)
+	return err
+}
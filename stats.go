@@ -0,0 +1,127 @@
+package client
+
+import (
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/connectivity"
+)
+
+// ShardStat describes the connection of a shard, see ConnectionStats
+type ShardStat struct {
+	Shard         int
+	Connected     bool               // whether the client holds a connection
+	State         connectivity.State // only meaningful when Connected
+	Address       string
+	EstablishedAt time.Time // zero when not Connected
+}
+
+// ConnectionStats reports the connection state of every shard. It only reads
+// what the client has cached, nothing is dialed
+func (me *Client) ConnectionStats() []ShardStat {
+	shards := me.shardList()
+	stats := make([]ShardStat, len(shards))
+	for no, s := range shards {
+		stat := ShardStat{Shard: no}
+		stat.Address, _ = me.address(no)
+
+		s.Lock()
+		if s.conn != nil {
+			stat.Connected = true
+			stat.State = s.conn.GetState()
+			stat.Address = s.addr
+			stat.EstablishedAt = s.since
+		}
+		s.Unlock()
+		stats[no] = stat
+	}
+	return stats
+}
+
+// ConnectedAddresses returns the address of every shard the client holds a
+// connection to, in shard order. Nothing is dialed
+func (me *Client) ConnectedAddresses() []string {
+	var addrs []string
+	for _, s := range me.shardList() {
+		s.Lock()
+		if s.conn != nil {
+			addrs = append(addrs, s.addr)
+		}
+		s.Unlock()
+	}
+	return addrs
+}
+
+// Stats is a snapshot of the publish counters of a client, see Client.Stats
+type Stats struct {
+	Sends      uint64   // publishes, retries of a publish count once
+	Failures   uint64   // publishes that returned an error
+	Bytes      uint64   // payload bytes of the successful publishes
+	ShardSends []uint64 // publishes per shard, indexed by shard
+}
+
+// counters holds the client wide publish counters, updated atomically
+type counters struct {
+	sends    uint64
+	failures uint64
+	bytes    uint64
+}
+
+// Stats returns the publish counters since the client was created. They are
+// kept with atomics whether or not WithMetricsCollector is set, so it is
+// cheap enough to poll. Only publishes that reached a shard are counted,
+// messages rejected locally (e.g. by validation or the rate limit) are not.
+// The counters of shards removed by SetMaxNodes are dropped from ShardSends
+func (me *Client) Stats() Stats {
+	shards := me.shardList()
+	stats := Stats{
+		Sends:      atomic.LoadUint64(&me.stats.sends),
+		Failures:   atomic.LoadUint64(&me.stats.failures),
+		Bytes:      atomic.LoadUint64(&me.stats.bytes),
+		ShardSends: make([]uint64, len(shards)),
+	}
+	for no, s := range shards {
+		stats.ShardSends[no] = atomic.LoadUint64(&s.sends)
+	}
+	return stats
+}
+
+// countPublish updates the counters with the result of a publish of size
+// payload bytes to shard no
+func (me *Client) countPublish(no, size int, err error) {
+	atomic.AddUint64(&me.stats.sends, 1)
+	if err != nil {
+		atomic.AddUint64(&me.stats.failures, 1)
+	} else {
+		atomic.AddUint64(&me.stats.bytes, uint64(size))
+	}
+
+	if s, err := me.shardAt(no); err == nil {
+		atomic.AddUint64(&s.sends, 1)
+		atomic.StoreInt64(&s.lastUsed, me.clock.Now().UnixNano())
+	}
+}
+
+// logShardLoad logs the publishes of every shard over each load log
+// interval until the client is closed, see WithShardLoadLog
+func (me *Client) logShardLoad() {
+	last := me.Stats().ShardSends
+	for {
+		select {
+		case <-me.clock.After(me.loadLogInterval):
+		case <-me.done:
+			return
+		}
+
+		sends := me.Stats().ShardSends
+		delta := make([]uint64, len(sends))
+		for no, n := range sends {
+			if no < len(last) && n >= last[no] {
+				n -= last[no]
+			}
+			delta[no] = n
+		}
+		last = sends
+		me.logger.Printf("realtime client: publishes per shard over the last %v: %v", me.loadLogInterval, delta)
+	}
+}
@@ -0,0 +1,160 @@
+package client
+
+import "fmt"
+
+// ErrPayloadTooLarge is returned when a payload is bigger than the limit set
+// with WithMaxPayloadSize
+var ErrPayloadTooLarge = newLocalReject("realtime client: payload too large")
+
+// ErrInvalidTopic is returned when a topic is empty or rejected by the
+// validator set with WithTopicValidator
+var ErrInvalidTopic = newLocalReject("realtime client: invalid topic")
+
+// ErrTooManyTopics is returned when a message has more topics than the
+// limit set with WithMaxTopics
+var ErrTooManyTopics = newLocalReject("realtime client: too many topics")
+
+// ErrNilPayload is returned when sending a nil payload. A nil payload
+// usually comes from a serialization bug, an intentionally empty message
+// must be sent as a non nil empty slice ([]byte{})
+var ErrNilPayload = newLocalReject("realtime client: nil payload")
+
+// ErrNoTopics is returned when sending a message without topics with
+// WithErrorOnEmptyTopics set
+var ErrNoTopics = newLocalReject("realtime client: no topics")
+
+// ErrInvalidAccount is returned when sending to an empty account ID, or one
+// rejected by the validator set with WithAccountValidator. It would be
+// routed to an arbitrary shard otherwise
+var ErrInvalidAccount = newLocalReject("realtime client: invalid account")
+
+// checkAccount validates the account ID of a message routed by account
+func (me *Client) checkAccount(accid string) error {
+	if accid == "" {
+		return fmt.Errorf("%w: empty account ID", ErrInvalidAccount)
+	}
+	if me.accountValidator != nil {
+		if err := me.accountValidator(accid); err != nil {
+			return fmt.Errorf("%w %q: %v", ErrInvalidAccount, accid, err)
+		}
+	}
+	return nil
+}
+
+// Validate runs the checks Send would run on a message without dialing or
+// sending anything, and returns the first failure: an invalid account ID
+// (ErrInvalidAccount), a nil payload (ErrNilPayload), too many topics
+// (ErrTooManyTopics), an empty or rejected topic (ErrInvalidTopic) and a
+// payload over the size limit (ErrPayloadTooLarge). Unlike Send, which
+// silently skips it, a message without topics is reported with
+// ErrInvalidTopic. Rate limits and circuit breakers aren't checked since
+// they depend on when the message is sent
+func (me *Client) Validate(accid string, topics []string, payload []byte) error {
+	if err := me.checkAccount(accid); err != nil {
+		return err
+	}
+	topics = me.expandTopics(topics)
+	if len(topics) == 0 {
+		return fmt.Errorf("%w: no topics", ErrInvalidTopic)
+	}
+	return me.checkMessage(me.dedupTopics(topics), payload)
+}
+
+// checkMessage runs the client side checks on a message before it is sent
+func (me *Client) checkMessage(topics []string, payload []byte) error {
+	if payload == nil {
+		return ErrNilPayload
+	}
+
+	if me.maxTopics > 0 && len(topics) > me.maxTopics {
+		return fmt.Errorf("%w: %d topics, max is %d", ErrTooManyTopics, len(topics), me.maxTopics)
+	}
+
+	for i, topic := range topics {
+		if topic == "" {
+			return fmt.Errorf("%w: topic %d is empty", ErrInvalidTopic, i)
+		}
+
+		if me.topicValidator != nil {
+			if err := me.topicValidator(topic); err != nil {
+				return fmt.Errorf("%w %q: %v", ErrInvalidTopic, topic, err)
+			}
+		}
+	}
+
+	if me.maxPayloadSize > 0 && len(payload) > me.maxPayloadSize {
+		return fmt.Errorf("%w: %d bytes, max is %d", ErrPayloadTooLarge, len(payload), me.maxPayloadSize)
+	}
+	return nil
+}
+
+// warnSize reports a payload over the WithSizeWarnThreshold threshold, from
+// another goroutine so a slow callback doesn't hold the send
+func (me *Client) warnSize(accid string, payload []byte) {
+	if me.sizeWarnFunc != nil && len(payload) > me.sizeWarn {
+		go me.sizeWarnFunc(accid, len(payload))
+	}
+}
+
+// emptyTopics returns the error of sending a message without topics to
+// accid: none unless WithErrorOnEmptyTopics is set, the message is skipped
+func (me *Client) emptyTopics(accid string) error {
+	if !me.strictTopics {
+		return nil
+	}
+	return fmt.Errorf("%w: account %s", ErrNoTopics, accid)
+}
+
+// dedupTopics drops the repeated topics when WithTopicDedup is set, keeping
+// the first occurrence of each. The caller's slice is never modified
+func (me *Client) dedupTopics(topics []string) []string {
+	if !me.topicDedup || len(topics) < 2 {
+		return topics
+	}
+
+	seen := make(map[string]bool, len(topics))
+	out := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		if !seen[topic] {
+			seen[topic] = true
+			out = append(out, topic)
+		}
+	}
+	return out
+}
+
+// expandTopics appends the topics set with WithDefaultTopics that topics
+// doesn't have yet, then adds the WithTopicPrefix prefix to all of them.
+// Empty topics stay empty so they are still rejected. The caller's slice
+// is never modified
+func (me *Client) expandTopics(topics []string) []string {
+	if len(me.defaultTopics) == 0 && me.topicPrefix == "" {
+		return topics
+	}
+
+	out := make([]string, len(topics), len(topics)+len(me.defaultTopics))
+	copy(out, topics)
+	for _, def := range me.defaultTopics {
+		if !hasTopic(out, def) {
+			out = append(out, def)
+		}
+	}
+
+	if me.topicPrefix != "" {
+		for i, topic := range out {
+			if topic != "" {
+				out[i] = me.topicPrefix + topic
+			}
+		}
+	}
+	return out
+}
+
+func hasTopic(topics []string, topic string) bool {
+	for _, t := range topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,8 @@
+package client
+
+// Version is the version of this package, sent in the default user agent
+const Version = "0.1.0"
+
+// defaultUserAgent identifies the client to the realtime service, see
+// WithUserAgent
+const defaultUserAgent = "subiz-realtime-client/" + Version